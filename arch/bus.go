@@ -0,0 +1,41 @@
+package arch
+
+// MemoryDevice is a device mapped into the CPU address space.
+// It handles all reads and writes the CPU performs in the mapped pages.
+type MemoryDevice interface {
+	ReadMemory(addr uint16) byte
+	WriteMemory(addr uint16, v byte)
+}
+
+// MemoryPageSize is the granularity of the device mapping.
+const MemoryPageSize = 0x100
+
+type memoryDevices [0x10000 / MemoryPageSize]MemoryDevice
+
+// MapDevice makes the device handle the memory pages that contain addresses from start to end.
+func (m *CPU) MapDevice(start, end uint16, dev MemoryDevice) {
+	for p := int(start) / MemoryPageSize; p <= int(end)/MemoryPageSize; p++ {
+		m.devices[p] = dev
+	}
+}
+
+// Device returns the device mapped at the address, or nil if the address is plain memory.
+// Devices that take over a part of another device's range use it to pass the other accesses through.
+func (m *CPU) Device(addr uint16) MemoryDevice { return m.devices[addr/MemoryPageSize] }
+
+// Read returns the value at the address as the CPU sees it.
+func (m *CPU) Read(addr uint16) byte {
+	if dev := m.devices[addr/MemoryPageSize]; dev != nil {
+		return dev.ReadMemory(addr)
+	}
+	return m.Memory[addr]
+}
+
+// Write stores the value at the address the same way the CPU does it.
+func (m *CPU) Write(addr uint16, v byte) {
+	if dev := m.devices[addr/MemoryPageSize]; dev != nil {
+		dev.WriteMemory(addr, v)
+		return
+	}
+	m.Memory[addr] = v
+}
@@ -1,19 +1,48 @@
 package arch
 
-import (
-	"sync"
-)
-
 // IoController represents К580ВВ55 (the analog of Intel 8255 microcontroller) that controls
 // interactions with the keyboard and other devices.
 // See https://en.wikipedia.org/wiki/Intel_8255
+//
+// The controller is mapped into the CPU memory and reacts to the register accesses as they happen.
+// Only the two lowest address bits are decoded, so the registers are mirrored every 4 bytes across
+// the MemRegisters2K section.
+// All three modes are supported: simple IO (mode 0), strobed IO (mode 1), and the bidirectional bus on port A (mode 2).
+// In modes 1 and 2 the handshake signals occupy port C pins, and their state is visible to the CPU reading port C.
+//
+// The controller is not safe for concurrent use: devices are supposed to send their values from the routine
+// that runs the CPU, which makes the simulation deterministic.
 type IoController struct {
 	mem []byte
 
-	a, b, cl, ch chan byte
+	clock     *uint64
+	outputs   [3]byte // Last values seen by the listeners.
+	listeners []*outputSubscription
+
+	ctl  byte    // Last mode set control word.
+	out  [3]byte // Output latches.
+	pins [3]byte // Values sent by the devices.
+	in   [2]byte // Input latches of ports A and B used in the strobed modes.
+
+	groupA, groupB handshake
+	intrA, intrB   func(active bool)
+}
+
+// handshake keeps the state of the strobed IO signals of a group.
+type handshake struct {
+	ibf   bool // Input buffer full.
+	obf   bool // Output buffer full (the OBF pin is active low).
+	acked bool // The device has acknowledged the output data.
+
+	inteIn, inteOut bool // Interrupt enable flags.
+	intr            bool
+}
 
-	umu     sync.Mutex // updates mutex
-	updates [3]byte
+func (h *handshake) updateIntr(input, output bool) (changed bool) {
+	intr := input && h.inteIn && h.ibf || output && h.inteOut && h.acked && !h.obf
+	changed = intr != h.intr
+	h.intr = intr
+	return
 }
 
 const (
@@ -23,150 +52,445 @@ const (
 	controlFlags
 )
 
+// Port C pins used by the strobed modes.
+const (
+	pinIntrB = 1 << iota
+	pinIbfB  // OBF in the output mode.
+	pinStbB  // ACK in the output mode.
+	pinIntrA
+	pinStbA
+	pinIbfA
+	pinAckA
+	pinObfA
+)
+
 func InitIoController(m *CPU) *IoController {
-	return &IoController{
+	c := &IoController{
 		mem: m.Memory[MemoryIoCtrl : MemoryIoCtrl+4],
 
-		a:  make(chan byte, 1),
-		b:  make(chan byte, 1),
-		cl: make(chan byte, 1),
-		ch: make(chan byte, 1),
+		clock: &m.Cycles,
+		// All ports are inputs after reset.
+		ctl:     0x9B,
+		outputs: [3]byte{0xFF, 0xFF, 0xFF},
 	}
+	start, end := MemoryMappingRange(MemRegisters2K)
+	m.MapDevice(uint16(start), uint16(end), c)
+	c.refreshMemory()
+	return c
 }
 
-// Sync propagates values between the [CPU.Memory] and controlled device that integrates with the ports A/B/C.
-// It is supposed to be called in the routine that works with attached CPU.
-func (c *IoController) Sync() {
-	if ctl := c.mem[controlFlags]; mask(ctl, 0x80) {
-		switch ioMode := (ctl >> 5) & 0x3; ioMode {
-		case 0:
-			c.syncSimpleIO(ctl)
-		case 1:
-			c.syncStrobedIO(ctl)
+// modeA returns the mode of group A (port A and upper port C).
+func (c *IoController) modeA() byte {
+	if mode := (c.ctl >> 5) & 0x3; mode < 2 {
+		return mode
+	}
+	return 2
+}
+
+// modeB returns the mode of group B (port B and lower port C).
+func (c *IoController) modeB() byte { return (c.ctl >> 2) & 1 }
+
+func (c *IoController) inputA() bool     { return mask(c.ctl, 0x10) }
+func (c *IoController) inputB() bool     { return mask(c.ctl, 0x02) }
+func (c *IoController) inputCLow() bool  { return mask(c.ctl, 0x01) }
+func (c *IoController) inputCHigh() bool { return mask(c.ctl, 0x08) }
+
+// ReadMemory implements MemoryDevice.
+func (c *IoController) ReadMemory(addr uint16) byte {
+	reg := int(addr & 0x3)
+	v := c.peek(reg)
+	switch {
+	case reg == portA && (c.modeA() == 2 || c.modeA() == 1 && c.inputA()):
+		c.groupA.ibf = false
+		c.updateInterrupts()
+	case reg == portB && c.modeB() == 1 && c.inputB():
+		c.groupB.ibf = false
+		c.updateInterrupts()
+	}
+	return v
+}
+
+// WriteMemory implements MemoryDevice.
+func (c *IoController) WriteMemory(addr uint16, v byte) {
+	switch addr & 0x3 {
+	case portA:
+		c.out[portA] = v
+		if c.modeA() == 2 || c.modeA() == 1 && !c.inputA() {
+			c.groupA.obf, c.groupA.acked = true, false
+		}
+	case portB:
+		c.out[portB] = v
+		if c.modeB() == 1 && !c.inputB() {
+			c.groupB.obf, c.groupB.acked = true, false
+		}
+	case portC:
+		c.out[portC] = v
+	default:
+		if mask(v, 0x80) {
+			c.setMode(v)
+		} else {
+			c.setBit((v>>1)&0x07, mask(v, 1))
+		}
+	}
+	c.updateInterrupts()
+}
+
+// setMode handles the mode set command. As the real chip, the controller clears all the latches.
+func (c *IoController) setMode(ctl byte) {
+	c.ctl = ctl
+	c.out, c.in = [3]byte{}, [2]byte{}
+	c.groupA, c.groupB = handshake{}, handshake{}
+}
+
+// setBit implements the bit set/reset command. In the strobed modes the pins used as handshake inputs
+// control the interrupt enable flags instead.
+func (c *IoController) setBit(bit byte, set bool) {
+	switch {
+	case bit == 4 && (c.modeA() == 2 || c.modeA() == 1 && c.inputA()):
+		c.groupA.inteIn = set
+	case bit == 6 && (c.modeA() == 2 || c.modeA() == 1 && !c.inputA()):
+		c.groupA.inteOut = set
+	case bit == 2 && c.modeB() == 1:
+		c.groupB.inteIn, c.groupB.inteOut = set, set
+	case set:
+		c.out[portC] |= 1 << bit
+	default:
+		c.out[portC] &^= 1 << bit
+	}
+}
+
+// peek returns the register value as the CPU would read it, without the side effects of the read.
+func (c *IoController) peek(reg int) byte {
+	switch reg {
+	case portA:
+		switch {
+		case c.modeA() == 2 || c.modeA() == 1 && c.inputA():
+			return c.in[portA]
+		case c.inputA():
+			return c.pins[portA]
 		default:
-			c.syncStrobedBidirectIO(ctl)
+			return c.out[portA]
 		}
-	} else {
-		c.syncBSR(ctl)
+	case portB:
+		switch {
+		case c.modeB() == 1 && c.inputB():
+			return c.in[portB]
+		case c.inputB():
+			return c.pins[portB]
+		default:
+			return c.out[portB]
+		}
+	case portC:
+		return c.readC()
+	default:
+		// Bit set/reset commands are not stored, the last mode word is read back.
+		return c.ctl
 	}
 }
 
-// ReceiveA obtains a value provided by the CPU after Sync is called.
-// This method will block until the value is provided.
-// The value is not provided until the port is set to the output mode by the CPU.
-func (c *IoController) ReceiveA() byte { return <-c.a }
+// readC composes port C from the IO pins and the handshake status.
+func (c *IoController) readC() byte {
+	var ioMask, status byte
+	if !c.inputCLow() {
+		ioMask |= 0x0F
+	}
+	if !c.inputCHigh() {
+		ioMask |= 0xF0
+	}
+	res := c.out[portC]&ioMask | c.pins[portC]&^ioMask
 
-// ReceiveB obtains a value provided by the CPU after Sync is called.
-// This method will block until the value is provided.
-// The value is not provided until the port is set to the output mode by the CPU.
-func (c *IoController) ReceiveB() byte { return <-c.b }
+	var used byte
+	switch a := &c.groupA; c.modeA() {
+	case 1:
+		if c.inputA() {
+			used = pinIntrA | pinStbA | pinIbfA
+			status = boolBit(a.intr, pinIntrA) | boolBit(a.inteIn, pinStbA) | boolBit(a.ibf, pinIbfA)
+		} else {
+			used = pinIntrA | pinAckA | pinObfA
+			status = boolBit(a.intr, pinIntrA) | boolBit(a.inteOut, pinAckA) | boolBit(!a.obf, pinObfA)
+		}
+	case 2:
+		used = pinIntrA | pinStbA | pinIbfA | pinAckA | pinObfA
+		status = boolBit(a.intr, pinIntrA) | boolBit(a.inteIn, pinStbA) | boolBit(a.ibf, pinIbfA) |
+			boolBit(a.inteOut, pinAckA) | boolBit(!a.obf, pinObfA)
+	}
+	if b := &c.groupB; c.modeB() == 1 {
+		used |= pinIntrB | pinIbfB | pinStbB
+		if c.inputB() {
+			status |= boolBit(b.intr, pinIntrB) | boolBit(b.ibf, pinIbfB) | boolBit(b.inteIn, pinStbB)
+		} else {
+			status |= boolBit(b.intr, pinIntrB) | boolBit(!b.obf, pinIbfB) | boolBit(b.inteOut, pinStbB)
+		}
+	}
+	return res&^used | status
+}
 
-// ReceiveCLow obtains a value provided by the CPU after Sync is called.
-// This method will block until the value is provided.
-// The value is not provided until the port is set to the output mode by the CPU.
-func (c *IoController) ReceiveCLow() byte { return <-c.cl }
+func boolBit(v bool, bit byte) byte {
+	if v {
+		return bit
+	}
+	return 0
+}
 
-// ReceiveCHigh obtains a value provided by the CPU after Sync is called.
-// This method will block until the value is provided.
-// The value is not provided until the port is set to the output mode by the CPU.
-func (c *IoController) ReceiveCHigh() byte { return <-c.ch }
+// updateInterrupts is called after every change of the controller state.
+// It updates the interrupt requests and notifies the output listeners.
+func (c *IoController) updateInterrupts() {
+	modeA := c.modeA()
+	inA := modeA == 2 || modeA == 1 && c.inputA()
+	outA := modeA == 2 || modeA == 1 && !c.inputA()
+	if c.groupA.updateIntr(inA, outA) && c.intrA != nil {
+		c.intrA(c.groupA.intr)
+	}
+	inB := c.modeB() == 1 && c.inputB()
+	outB := c.modeB() == 1 && !c.inputB()
+	if c.groupB.updateIntr(inB, outB) && c.intrB != nil {
+		c.intrB(c.groupB.intr)
+	}
+	c.notifyListeners()
+	c.refreshMemory()
+}
 
-// SendA sets the value that should be visible to the CPU after the next Sync.
-// The value is used only if the port is set to the input mode.
-func (c *IoController) SendA(v byte) { c.update(portA, v) }
+// refreshMemory keeps the values visible in the CPU memory up to date, so that memory dumps reflect
+// the controller state. Only the main registers location (MemoryIoCtrl) is updated.
+func (c *IoController) refreshMemory() {
+	for reg := range c.mem {
+		c.mem[reg] = c.peek(reg)
+	}
+}
 
-// SendB sets the value that should be visible to the CPU after the next Sync.
-// The value is used only if the port is set to the input mode.
-func (c *IoController) SendB(v byte) { c.update(portB, v) }
+// Port identifies a port of the IoController.
+type Port int
 
-// SendCLow sets the value that should be visible to the CPU after the next Sync.
-// The value is used only if the port is set to the input mode.
-func (c *IoController) SendCLow(v byte) { c.updateC(true, v) }
+const (
+	PortA Port = iota
+	PortB
+	PortC
+)
 
-// SendCHigh sets the value that should be visible to the CPU after the next Sync.
-// The value is used only if the port is set to the input mode.
-func (c *IoController) SendCHigh(v byte) { c.updateC(false, v) }
+// OutputListener is called when the output pins of a port change.
+// The value contains the state of all the port pins at the moment, the pins not driven by the controller
+// (working as inputs) are pulled up and read as 1.
+type OutputListener func(cycle uint64, value byte)
 
-func (c *IoController) update(i int, v byte) {
-	c.umu.Lock()
-	c.updates[i] = v
-	c.umu.Unlock()
+type outputSubscription struct {
+	port Port
+	mask byte
+	f    OutputListener
 }
 
-func (c *IoController) updateC(low bool, v byte) {
-	c.umu.Lock()
-	if low {
-		c.updates[portC] = (c.updates[portC] & 0xF0) | (v & 0x0F)
-	} else {
-		c.updates[portC] = (c.updates[portC] & 0x0F) | (v << 4)
+// Subscribe registers a listener of the port output changes. The listener is notified only when any of the pins
+// selected with the mask changes, so it's possible to listen to a particular bit.
+// Listeners are called synchronously, in the order of the changes, and get the CPU cycle of the instruction
+// that caused the change.
+// The returned function removes the listener.
+func (c *IoController) Subscribe(port Port, mask byte, f OutputListener) (unsubscribe func()) {
+	sub := &outputSubscription{port: port, mask: mask, f: f}
+	c.listeners = append(c.listeners, sub)
+	return func() {
+		for i, s := range c.listeners {
+			if s == sub {
+				c.listeners = append(c.listeners[:i:i], c.listeners[i+1:]...)
+				return
+			}
+		}
 	}
-	c.umu.Unlock()
 }
 
-func (c *IoController) syncSimpleIO(ctl byte) {
-	c.umu.Lock()
-	c.lSyncPortSimple(portA, mask(ctl, 0x10))
-	c.lSyncPortSimple(portB, mask(ctl, 0x02))
-	c.lSyncPortCSimple(true, mask(ctl, 0x01))
-	c.lSyncPortCSimple(false, mask(ctl, 0x08))
-	c.umu.Unlock()
+// Output returns the current state of the port output pins, as it's seen by the listeners.
+func (c *IoController) Output(port Port) byte {
+	switch port {
+	case PortA:
+		if c.modeA() < 2 && !c.inputA() {
+			return c.out[portA]
+		}
+	case PortB:
+		if !c.inputB() {
+			return c.out[portB]
+		}
+	case PortC:
+		return c.outputC()
+	}
+	return 0xFF
 }
 
-func (c *IoController) lSyncPortSimple(port int, inputMode bool) {
-	if inputMode {
-		c.mem[port] = c.updates[port]
-	} else {
-		var conn chan<- byte
-		switch port {
-		case portA:
-			conn = c.a
-		case portB:
-			conn = c.b
-		default:
-			panic("bad port")
+// Driven returns the mask of the port pins driven by the controller.
+// Other pins work as inputs.
+func (c *IoController) Driven(port Port) byte {
+	switch port {
+	case PortA:
+		if c.modeA() < 2 && !c.inputA() {
+			return 0xFF
+		}
+	case PortB:
+		if !c.inputB() {
+			return 0xFF
 		}
-		sendOutValue(conn, c.mem[port])
+	case PortC:
+		var res byte
+		if !c.inputCLow() {
+			res |= 0x0F
+		}
+		if !c.inputCHigh() {
+			res |= 0xF0
+		}
+		switch c.modeA() {
+		case 1:
+			res = res&^(pinStbA|pinIbfA|pinAckA|pinObfA) | pinIntrA
+			if c.inputA() {
+				res |= pinIbfA
+			} else {
+				res |= pinObfA
+			}
+		case 2:
+			res = res&^(pinStbA|pinAckA) | pinIntrA | pinIbfA | pinObfA
+		}
+		if c.modeB() == 1 {
+			res = res&^pinStbB | pinIntrB | pinIbfB
+		}
+		return res
 	}
+	return 0
 }
 
-func (c *IoController) lSyncPortCSimple(low bool, inputMode bool) {
-	if inputMode {
-		if low {
-			c.mem[portC] = (c.mem[portC] & 0xF0) | (c.updates[portC] & 0x0F)
+// outputC returns the port C pins driven by the controller, including the handshake outputs.
+func (c *IoController) outputC() byte {
+	var ioMask byte
+	if !c.inputCLow() {
+		ioMask |= 0x0F
+	}
+	if !c.inputCHigh() {
+		ioMask |= 0xF0
+	}
+	res := c.out[portC]&ioMask | ^ioMask
+
+	var used, status byte
+	switch a := &c.groupA; c.modeA() {
+	case 1:
+		if c.inputA() {
+			used = pinIntrA | pinStbA | pinIbfA
+			status = boolBit(a.intr, pinIntrA) | pinStbA | boolBit(a.ibf, pinIbfA)
 		} else {
-			c.mem[portC] = (c.mem[portC] & 0x0F) | (c.updates[portC] & 0xF0)
+			used = pinIntrA | pinAckA | pinObfA
+			status = boolBit(a.intr, pinIntrA) | pinAckA | boolBit(!a.obf, pinObfA)
 		}
-	} else {
-		if low {
-			sendOutValue(c.cl, c.mem[portC]&0x0F)
+	case 2:
+		used = pinIntrA | pinStbA | pinIbfA | pinAckA | pinObfA
+		status = boolBit(a.intr, pinIntrA) | pinStbA | boolBit(a.ibf, pinIbfA) | pinAckA | boolBit(!a.obf, pinObfA)
+	}
+	if b := &c.groupB; c.modeB() == 1 {
+		used |= pinIntrB | pinIbfB | pinStbB
+		if c.inputB() {
+			status |= boolBit(b.intr, pinIntrB) | boolBit(b.ibf, pinIbfB) | pinStbB
 		} else {
-			sendOutValue(c.ch, c.mem[portC]>>4)
+			status |= boolBit(b.intr, pinIntrB) | boolBit(!b.obf, pinIbfB) | pinStbB
+		}
+	}
+	return res&^used | status
+}
+
+func (c *IoController) notifyListeners() {
+	for port := range c.outputs {
+		prev, v := c.outputs[port], c.Output(Port(port))
+		if prev == v {
+			continue
+		}
+		c.outputs[port] = v
+		for _, sub := range c.listeners {
+			if sub.port == Port(port) && (prev^v)&sub.mask != 0 {
+				sub.f(*c.clock, v)
+			}
 		}
 	}
 }
 
-func (c *IoController) syncStrobedIO(byte) {
-	panic("not implemented")
+// SendA sets the value that is immediately visible to the CPU.
+// The value is used only if the port is set to the input mode.
+func (c *IoController) SendA(v byte) { c.update(portA, v) }
+
+// SendB sets the value that is immediately visible to the CPU.
+// The value is used only if the port is set to the input mode.
+func (c *IoController) SendB(v byte) { c.update(portB, v) }
+
+// SendCLow sets the value that is immediately visible to the CPU.
+// The value is used only if the port is set to the input mode.
+func (c *IoController) SendCLow(v byte) { c.updateC(true, v) }
+
+// SendCHigh sets the value that is immediately visible to the CPU.
+// The value is used only if the port is set to the input mode.
+func (c *IoController) SendCHigh(v byte) { c.updateC(false, v) }
+
+// PortC returns the value of port C as the CPU reads it.
+func (c *IoController) PortC() byte { return c.readC() }
+
+// StrobeA latches the value in port A as the device does it pulsing the STB signal in mode 1 or mode 2.
+// It returns false if port A does not work as a strobed input.
+func (c *IoController) StrobeA(v byte) bool {
+	if mode := c.modeA(); mode != 2 && (mode != 1 || !c.inputA()) {
+		return false
+	}
+	c.in[portA] = v
+	c.groupA.ibf = true
+	c.updateInterrupts()
+	return true
+}
+
+// StrobeB latches the value in port B as the device does it pulsing the STB signal in mode 1.
+// It returns false if port B does not work as a strobed input.
+func (c *IoController) StrobeB(v byte) bool {
+	if c.modeB() != 1 || !c.inputB() {
+		return false
+	}
+	c.in[portB] = v
+	c.groupB.ibf = true
+	c.updateInterrupts()
+	return true
 }
 
-func (c *IoController) syncStrobedBidirectIO(byte) {
-	panic("not implemented")
+// AckA acknowledges the output data of port A in mode 1 or mode 2 and returns it.
+// In mode 2 port A drives the bus only while ACK is active, so this is the only way to read it.
+// It returns false if port A does not work as a strobed output.
+func (c *IoController) AckA() (byte, bool) {
+	if mode := c.modeA(); mode != 2 && (mode != 1 || c.inputA()) {
+		return 0, false
+	}
+	c.groupA.obf, c.groupA.acked = false, true
+	c.updateInterrupts()
+	return c.out[portA], true
 }
 
-func (c *IoController) syncBSR(ctl byte) {
-	selector := (ctl >> 1) & 0x07
-	if mask(ctl, 1) {
-		c.mem[portC] |= 1 << selector
-	} else {
-		c.mem[portC] &^= 1 << selector
+// AckB acknowledges the output data of port B in mode 1 and returns it.
+// It returns false if port B does not work as a strobed output.
+func (c *IoController) AckB() (byte, bool) {
+	if c.modeB() != 1 || c.inputB() {
+		return 0, false
 	}
-	sendOutValue(c.ch, c.mem[portC]>>4)
-	sendOutValue(c.cl, c.mem[portC]&0x0F)
+	c.groupB.obf, c.groupB.acked = false, true
+	c.updateInterrupts()
+	return c.out[portB], true
 }
 
-func sendOutValue(conn chan<- byte, val byte) {
-	select {
-	case conn <- val:
-	default:
+// IntrA returns the state of the group A interrupt request output (pin 3 of port C).
+func (c *IoController) IntrA() bool { return c.groupA.intr }
+
+// IntrB returns the state of the group B interrupt request output (pin 0 of port C).
+func (c *IoController) IntrB() bool { return c.groupB.intr }
+
+// ConnectInterrupts sets the functions called when the interrupt request outputs change.
+// Any of the functions can be nil.
+func (c *IoController) ConnectInterrupts(intrA, intrB func(active bool)) {
+	c.intrA, c.intrB = intrA, intrB
+}
+
+func (c *IoController) update(i int, v byte) {
+	c.pins[i] = v
+	c.refreshMemory()
+}
+
+func (c *IoController) updateC(low bool, v byte) {
+	if low {
+		c.pins[portC] = (c.pins[portC] & 0xF0) | (v & 0x0F)
+	} else {
+		c.pins[portC] = (c.pins[portC] & 0x0F) | (v << 4)
 	}
+	c.refreshMemory()
 }
@@ -10,7 +10,7 @@ func TestIoController(t *testing.T) {
 		ioc = InitIoController(&cpu)
 	)
 
-	baseAddress := MemoryIoCtrl
+	baseAddress := uint16(MemoryIoCtrl)
 
 	type commValue struct {
 		name string
@@ -23,13 +23,19 @@ func TestIoController(t *testing.T) {
 		sendF  func(byte)
 	}
 
+	var received [3]byte
+	for port := range received {
+		ioc.Subscribe(Port(port), 0xFF, func(_ uint64, v byte) { received[port] = v })
+	}
+
 	ports := map[string]portInfo{
-		"A": {offset: 0, recvF: ioc.ReceiveA, sendF: ioc.SendA},
-		"B": {offset: 1, recvF: ioc.ReceiveB, sendF: ioc.SendB},
+		"A": {offset: 0, recvF: func() byte { return received[PortA] }, sendF: ioc.SendA},
+		"B": {offset: 1, recvF: func() byte { return received[PortB] }, sendF: ioc.SendB},
 
-		"C":  {offset: 2},
-		"CL": {recvF: ioc.ReceiveCLow, sendF: ioc.SendCLow},
-		"CH": {recvF: ioc.ReceiveCHigh, sendF: ioc.SendCHigh},
+		"C":   {offset: 2},
+		"CTL": {offset: 3},
+		"CL":  {recvF: func() byte { return received[PortC] & 0x0F }, sendF: ioc.SendCLow},
+		"CH":  {recvF: func() byte { return received[PortC] >> 4 }, sendF: ioc.SendCHigh},
 	}
 
 	port := func(name string) portInfo {
@@ -70,9 +76,10 @@ func TestIoController(t *testing.T) {
 		},
 		{
 			name: "bsr/simple",
-			ctl:  0x0B, // Set 5th bit of C.
+			ctl:  0x82,
 			cpuWrites: []commValue{
 				{name: "C", val: 1},
+				{name: "CTL", val: 0x0B}, // Set 5th bit of C.
 			},
 			ioRecv: []commValue{
 				{name: "CL", val: 1},
@@ -104,18 +111,16 @@ func TestIoController(t *testing.T) {
 		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
-			cpu.Memory[baseAddress+3] = tc.ctl
+			cpu.Write(baseAddress+3, tc.ctl)
 
 			for _, w := range tc.cpuWrites {
-				cpu.Memory[baseAddress+port(w.name).offset] = w.val
+				cpu.Write(baseAddress+uint16(port(w.name).offset), w.val)
 			}
 
 			for _, s := range tc.ioSend {
 				port(s.name).sendF(s.val)
 			}
 
-			ioc.Sync()
-
 			for _, r := range tc.ioRecv {
 				val := port(r.name).recvF()
 				if val != r.val {
@@ -123,7 +128,7 @@ func TestIoController(t *testing.T) {
 				}
 			}
 			for _, r := range tc.cpuReads {
-				val := cpu.Memory[baseAddress+port(r.name).offset]
+				val := cpu.Read(baseAddress + uint16(port(r.name).offset))
 				if val != r.val {
 					t.Errorf("got %v reading for %s; want %v", val, r.name, r.val)
 				}
@@ -131,3 +136,269 @@ func TestIoController(t *testing.T) {
 		})
 	}
 }
+
+func TestIoControllerStrobed(t *testing.T) {
+	const (
+		regA = MemoryIoCtrl + iota
+		regB
+		regC
+		regCtl
+	)
+
+	type step struct {
+		write   map[uint16]byte // CPU writes.
+		strobeA *byte
+		strobeB *byte
+		ackA    bool
+		ackB    bool
+
+		wantRead map[uint16]byte // CPU reads, checked in the order of the registers.
+		wantAck  byte
+		wantC    *byte // Port C checked before the reads.
+		intrA    bool
+		intrB    bool
+	}
+	val := func(v byte) *byte { return &v }
+
+	for _, tc := range []struct {
+		name  string
+		ctl   byte
+		steps []step
+	}{
+		{
+			name: "mode1/A=input",
+			ctl:  0xB0,
+			steps: []step{
+				{wantC: val(0x00)},
+				{write: map[uint16]byte{regCtl: 0x09}, wantC: val(0x10)}, // INTE A.
+				{strobeA: val(0x42), wantC: val(0x38), intrA: true},
+				{wantRead: map[uint16]byte{regA: 0x42}},
+				{wantC: val(0x10)},
+			},
+		},
+		{
+			name: "mode1/A=input/no interrupts",
+			ctl:  0xB0,
+			steps: []step{
+				{strobeA: val(0x42), wantC: val(0x20)},
+				{wantRead: map[uint16]byte{regA: 0x42}, wantC: val(0x20)},
+				{wantC: val(0x00)},
+			},
+		},
+		{
+			name: "mode1/A=output",
+			ctl:  0xA0,
+			steps: []step{
+				{write: map[uint16]byte{regCtl: 0x0D}, wantC: val(0xC0)}, // INTE A.
+				{write: map[uint16]byte{regA: 0x55}, wantC: val(0x40)},
+				{ackA: true, wantAck: 0x55, wantC: val(0xC8), intrA: true},
+				{write: map[uint16]byte{regA: 0x56}, wantC: val(0x40)},
+			},
+		},
+		{
+			name: "mode1/B=input",
+			ctl:  0x86,
+			steps: []step{
+				{write: map[uint16]byte{regCtl: 0x05}, wantC: val(0x04)}, // INTE B.
+				{strobeB: val(0x24), wantC: val(0x07), intrB: true},
+				{wantRead: map[uint16]byte{regB: 0x24}, wantC: val(0x07)},
+				{wantC: val(0x04)},
+			},
+		},
+		{
+			name: "mode1/B=output",
+			ctl:  0x84,
+			steps: []step{
+				{write: map[uint16]byte{regCtl: 0x05}, wantC: val(0x06)}, // INTE B.
+				{write: map[uint16]byte{regB: 0x33}, wantC: val(0x04)},
+				{ackB: true, wantAck: 0x33, wantC: val(0x07), intrB: true},
+			},
+		},
+		{
+			name: "mode1/C pins",
+			ctl:  0xB8, // Group A input, PC6 and PC7 are inputs, lower half is output.
+			steps: []step{
+				{write: map[uint16]byte{regC: 0xFF}, wantC: val(0x07)},
+				{write: map[uint16]byte{regCtl: 0x0F}, wantC: val(0x07)},
+				{write: map[uint16]byte{regCtl: 0x02}, wantC: val(0x05)},
+			},
+		},
+		{
+			name: "mode2",
+			ctl:  0xC0,
+			steps: []step{
+				{wantC: val(0x80)},
+				{write: map[uint16]byte{regCtl: 0x09}, wantC: val(0x90)}, // INTE2.
+				{write: map[uint16]byte{regCtl: 0x0D}, wantC: val(0xD0)}, // INTE1.
+				{write: map[uint16]byte{regA: 0x11}, wantC: val(0x50)},
+				{strobeA: val(0x22), wantC: val(0x78), intrA: true},
+				{ackA: true, wantAck: 0x11, wantC: val(0xF8), intrA: true},
+				{wantRead: map[uint16]byte{regA: 0x22}, intrA: true},
+				{wantC: val(0xD8), intrA: true},
+				{write: map[uint16]byte{regA: 0x12}, wantC: val(0x50)},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				cpu          CPU
+				ioc          = InitIoController(&cpu)
+				intrA, intrB bool
+			)
+			ioc.ConnectInterrupts(func(v bool) { intrA = v }, func(v bool) { intrB = v })
+			cpu.Write(regCtl, tc.ctl)
+
+			for i, s := range tc.steps {
+				for _, addr := range []uint16{regA, regB, regC, regCtl} {
+					if v, ok := s.write[addr]; ok {
+						cpu.Write(addr, v)
+					}
+				}
+				if s.strobeA != nil && !ioc.StrobeA(*s.strobeA) {
+					t.Errorf("step %d: strobe A not accepted", i)
+				}
+				if s.strobeB != nil && !ioc.StrobeB(*s.strobeB) {
+					t.Errorf("step %d: strobe B not accepted", i)
+				}
+				ack, ok := byte(0), true
+				if s.ackA {
+					ack, ok = ioc.AckA()
+				}
+				if s.ackB {
+					ack, ok = ioc.AckB()
+				}
+				if !ok {
+					t.Errorf("step %d: ack not accepted", i)
+				}
+				if ack != s.wantAck {
+					t.Errorf("step %d: got 0x%02x acknowledged; want 0x%02x", i, ack, s.wantAck)
+				}
+				if s.wantC != nil {
+					if c := ioc.PortC(); c != *s.wantC {
+						t.Errorf("step %d: got port C 0b%08b; want 0b%08b", i, c, *s.wantC)
+					}
+				}
+				for _, addr := range []uint16{regA, regB, regC, regCtl} {
+					if want, ok := s.wantRead[addr]; ok {
+						if v := cpu.Read(addr); v != want {
+							t.Errorf("step %d: got 0x%02x reading 0x%04x; want 0x%02x", i, v, addr, want)
+						}
+					}
+				}
+				if intrA != s.intrA || ioc.IntrA() != s.intrA {
+					t.Errorf("step %d: got INTR A %t (%t); want %t", i, ioc.IntrA(), intrA, s.intrA)
+				}
+				if intrB != s.intrB || ioc.IntrB() != s.intrB {
+					t.Errorf("step %d: got INTR B %t (%t); want %t", i, ioc.IntrB(), intrB, s.intrB)
+				}
+			}
+		})
+	}
+
+	t.Run("mode0/no handshake", func(t *testing.T) {
+		var cpu CPU
+		ioc := InitIoController(&cpu)
+		if ioc.StrobeA(1) || ioc.StrobeB(1) {
+			t.Error("strobe accepted in mode 0")
+		}
+		if _, ok := ioc.AckA(); ok {
+			t.Error("ack A accepted in mode 0")
+		}
+		if _, ok := ioc.AckB(); ok {
+			t.Error("ack B accepted in mode 0")
+		}
+	})
+}
+
+func TestIoControllerRegisters(t *testing.T) {
+	var cpu CPU
+	ioc := InitIoController(&cpu)
+
+	if v := cpu.Read(MemoryIoCtrl + 3); v != 0x9B {
+		t.Errorf("got 0x%02x control word after reset; want 0x9b", v)
+	}
+
+	// Registers are mirrored every 4 bytes.
+	cpu.Write(0xF803, 0x80)
+	cpu.Write(0xFA02, 0x5A)
+	cpu.Write(0xF801, 0x33)
+	for _, addr := range []uint16{0xF802, 0xFC06, 0xFF02, 0xFFFE} {
+		if v := cpu.Read(addr); v != 0x5A {
+			t.Errorf("got 0x%02x reading port C at 0x%04x; want 0x5a", v, addr)
+		}
+	}
+	if v := cpu.Read(0xFF05); v != 0x33 {
+		t.Errorf("got 0x%02x reading port B mirror; want 0x33", v)
+	}
+	cpu.Write(0xFF00, 0x77)
+	for _, addr := range []uint16{0xF800, 0xFFFC} {
+		if v := cpu.Read(addr); v != 0x77 {
+			t.Errorf("got 0x%02x reading port A at 0x%04x; want 0x77", v, addr)
+		}
+	}
+	if v := cpu.Memory[MemoryIoCtrl+2]; v != 0x5A {
+		t.Errorf("got 0x%02x in the memory dump of port C; want 0x5a", v)
+	}
+	if v := cpu.Read(0xF7FF); v != 0 {
+		t.Errorf("got 0x%02x outside of the registers section; want 0", v)
+	}
+
+	// Bit set/reset does not change the control word.
+	cpu.Write(0xFFFF, 0x01)
+	if v := cpu.Read(0xF803); v != 0x80 {
+		t.Errorf("got 0x%02x control word after bit set; want 0x80", v)
+	}
+	if v := ioc.PortC(); v != 0x5B {
+		t.Errorf("got 0x%02x port C after bit set; want 0x5b", v)
+	}
+
+	// Mode set clears the output latches.
+	cpu.Write(0xFF03, 0x80)
+	for _, addr := range []uint16{0xFF00, 0xFF01, 0xFF02} {
+		if v := cpu.Read(addr); v != 0 {
+			t.Errorf("got 0x%02x at 0x%04x after mode set; want 0", v, addr)
+		}
+	}
+}
+
+func TestIoControllerSubscribe(t *testing.T) {
+	var cpu CPU
+	ioc := InitIoController(&cpu)
+
+	type change struct {
+		cycle uint64
+		value byte
+	}
+	var all, bit5, portA []change
+	ioc.Subscribe(PortC, 0xFF, func(cycle uint64, v byte) { all = append(all, change{cycle, v}) })
+	ioc.Subscribe(PortC, 0x20, func(cycle uint64, v byte) { bit5 = append(bit5, change{cycle, v}) })
+	unsubscribe := ioc.Subscribe(PortA, 0xFF, func(cycle uint64, v byte) { portA = append(portA, change{cycle, v}) })
+
+	write := func(cycle uint64, addr uint16, v byte) {
+		cpu.Cycles = cycle
+		cpu.Write(addr, v)
+	}
+	write(10, MemoryIoCtrl+3, 0x82) // A and C are outputs, all latches are reset.
+	write(20, MemoryIoCtrl+3, 0x0B) // Set PC5.
+	write(30, MemoryIoCtrl+3, 0x0B) // No change.
+	write(40, MemoryIoCtrl+3, 0x0F) // Set PC7.
+	write(50, MemoryIoCtrl+3, 0x0A) // Reset PC5.
+	write(60, MemoryIoCtrl, 0x42)
+	unsubscribe()
+	write(70, MemoryIoCtrl, 0x43)
+
+	check := func(name string, got, want []change) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("got %d changes of %s; want %d", len(got), name, len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("got change %d of %s %+v; want %+v", i, name, got[i], want[i])
+			}
+		}
+	}
+	check("port C", all, []change{{10, 0x00}, {20, 0x20}, {40, 0xA0}, {50, 0x80}})
+	check("PC5", bit5, []change{{10, 0x00}, {20, 0x20}, {50, 0x80}})
+	check("port A", portA, []change{{10, 0x00}, {60, 0x42}})
+}
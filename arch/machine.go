@@ -80,6 +80,10 @@ type CPU struct {
 	Memory     Memory // 64KB memory space
 	Interrupts bool
 	In, Out    Ports
+
+	Cycles uint64 // Number of cycles executed by Step
+
+	devices memoryDevices
 }
 
 func (m *CPU) String() string {
@@ -101,6 +105,7 @@ func (m *CPU) Step() (Instruction, int, error) {
 		return cmd, 0, err
 	}
 	c := m.Exec(cmd)
+	m.Cycles += uint64(c)
 	return cmd, c, nil
 }
 
@@ -156,32 +161,44 @@ const (
 	RegisterSelA
 )
 
-func (m *CPU) selectOperand(s byte) (reg *byte, mem []byte) {
+func (m *CPU) selectRegister(s byte) *byte {
 	switch s {
 	case RegisterSelA:
-		reg = &m.Registers.A
+		return &m.Registers.A
 	case RegisterSelB:
-		reg = &m.Registers.B
+		return &m.Registers.B
 	case RegisterSelC:
-		reg = &m.Registers.C
+		return &m.Registers.C
 	case RegisterSelD:
-		reg = &m.Registers.D
+		return &m.Registers.D
 	case RegisterSelE:
-		reg = &m.Registers.E
+		return &m.Registers.E
 	case RegisterSelH:
-		reg = &m.Registers.H
+		return &m.Registers.H
 	case RegisterSelL:
-		reg = &m.Registers.L
-	case RegisterSelMemory:
-		addr := uint16(m.Registers.H)<<8 | uint16(m.Registers.L)
-		if addr > 0xFFFF {
-			panic(fmt.Errorf("memory address out of range %04x", addr))
-		}
-		mem = m.Memory[addr:]
+		return &m.Registers.L
 	default:
 		panic(fmt.Errorf("invalid selector %02x", s))
 	}
-	return
+}
+
+func (m *CPU) addressHL() uint16 { return uint16(m.Registers.H)<<8 | uint16(m.Registers.L) }
+
+// operand returns the value of a register or the memory referenced by H:L.
+func (m *CPU) operand(s byte) byte {
+	if s == RegisterSelMemory {
+		return m.Read(m.addressHL())
+	}
+	return *m.selectRegister(s)
+}
+
+// setOperand stores the value to a register or the memory referenced by H:L.
+func (m *CPU) setOperand(s byte, v byte) {
+	if s == RegisterSelMemory {
+		m.Write(m.addressHL(), v)
+	} else {
+		*m.selectRegister(s) = v
+	}
 }
 
 const (
@@ -212,23 +229,23 @@ func (m *CPU) selectDoubleOperand(s byte) (r1, r2 *byte, sp *uint16) {
 
 func (m *CPU) push8(v byte) {
 	m.SP--
-	m.Memory[m.SP] = v
+	m.Write(m.SP, v)
 }
 
 func (m *CPU) push16(v uint16) {
-	m.Memory[m.SP-1] = byte(v >> 8)
-	m.Memory[m.SP-2] = byte(v & 0xFF)
+	m.Write(m.SP-1, byte(v>>8))
+	m.Write(m.SP-2, byte(v&0xFF))
 	m.SP -= 2
 }
 
 func (m *CPU) pop8() byte {
-	r := m.Memory[m.SP]
+	r := m.Read(m.SP)
 	m.SP++
 	return r
 }
 
 func (m *CPU) pop16() uint16 {
-	r := uint16(m.Memory[m.SP]) | uint16(m.Memory[m.SP+1])<<8
+	r := uint16(m.Read(m.SP)) | uint16(m.Read(m.SP+1))<<8
 	m.SP += 2
 	return r
 }
@@ -34,8 +34,12 @@ var memoryMapping = [memSectionsCnt]int{
 }
 
 // MemoryMappingRange returns the start and end address of a particular memory section.
+// The end address is inclusive.
 func MemoryMappingRange(s MemSection) (start int, end int) {
-	return memoryMapping[s], memoryMapping[(s+1)%memSectionsCnt] - 1
+	if s == memSectionsCnt-1 {
+		return memoryMapping[s], 0xFFFF
+	}
+	return memoryMapping[s], memoryMapping[s+1] - 1
 }
 
 // MemoryMapping returns the start address of the selected memory section.
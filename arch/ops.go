@@ -2,20 +2,6 @@ package arch
 
 import "fmt"
 
-func lookup8(r *byte, mem []byte) int16 {
-	if r != nil {
-		return int16(*r)
-	}
-	return int16(mem[0])
-}
-
-func ref8(r *byte, mem []byte) *byte {
-	if r != nil {
-		return r
-	}
-	return &mem[0]
-}
-
 func lookup32(r1, r2 *byte, sp *uint16) int32 {
 	if sp != nil {
 		return int32(*sp)
@@ -62,7 +48,7 @@ func ADC(r byte) Instruction {
 		Name: fmt.Sprintf("ADC %s", RegisterCode(r)),
 		Size: 1,
 		Execute: func(m *CPU) int {
-			incA(m, lookup8(m.selectOperand(r)), true)
+			incA(m, int16(m.operand(r)), true)
 			return 2
 		},
 	}
@@ -74,7 +60,7 @@ func ADD(r byte) Instruction {
 		Name: fmt.Sprintf("ADD %s", RegisterCode(r)),
 		Size: 1,
 		Execute: func(m *CPU) int {
-			incA(m, lookup8(m.selectOperand(r)), false)
+			incA(m, int16(m.operand(r)), false)
 			return 2
 		},
 	}
@@ -111,7 +97,7 @@ func ANA(r byte) Instruction {
 	return Instruction{
 		Name:    fmt.Sprintf("ANA %s", RegisterCode(r)),
 		Size:    1,
-		Execute: func(m *CPU) int { andA(m, byte(lookup8(m.selectOperand(r)))); return 2 },
+		Execute: func(m *CPU) int { andA(m, m.operand(r)); return 2 },
 	}
 }
 
@@ -187,7 +173,7 @@ func CMP(r byte) Instruction {
 	return Instruction{
 		Name:    fmt.Sprintf("CMP %s", RegisterCode(r)),
 		Size:    1,
-		Execute: func(m *CPU) int { cmpA(m, lookup8(m.selectOperand(r))); return 2 },
+		Execute: func(m *CPU) int { cmpA(m, int16(m.operand(r))); return 2 },
 	}
 }
 
@@ -249,7 +235,9 @@ func DCR(r byte) Instruction {
 		Name: fmt.Sprintf("DCR %s", RegisterCode(r)),
 		Size: 1,
 		Execute: func(m *CPU) int {
-			addDst(m, ref8(m.selectOperand(r)), -1, false)
+			v := m.operand(r)
+			addDst(m, &v, -1, false)
+			m.setOperand(r, v)
 			return 1
 		},
 	}
@@ -307,11 +295,11 @@ func POP(rp byte) Instruction {
 				h = &m.Registers.A
 			}
 			if l == nil {
-				m.setPSW(m.Memory[m.SP])
+				m.setPSW(m.Read(m.SP))
 			} else {
-				*l = m.Memory[m.SP]
+				*l = m.Read(m.SP)
 			}
-			*h = m.Memory[m.SP+1]
+			*h = m.Read(m.SP + 1)
 			m.SP += 2
 			return 3
 		},
@@ -457,14 +445,7 @@ func SBB(r byte) Instruction {
 		Name: fmt.Sprintf("SBB %s", RegisterCode(r)),
 		Size: 1,
 		Execute: func(m *CPU) int {
-			reg, mem := m.selectOperand(r)
-			var val int16
-			if mem != nil {
-				val = int16(mem[0])
-			} else {
-				val = int16(*reg)
-			}
-			addDst(m, &m.Registers.A, -val, true)
+			addDst(m, &m.Registers.A, -int16(m.operand(r)), true)
 			return 2
 		},
 	}
@@ -488,8 +469,8 @@ func SHLD(addr uint16) Instruction {
 		Name: fmt.Sprintf("SHLD 0x%04x", addr),
 		Size: 3,
 		Execute: func(m *CPU) int {
-			m.Memory[addr] = m.Registers.L
-			m.Memory[addr+1] = m.Registers.H
+			m.Write(addr, m.Registers.L)
+			m.Write(addr+1, m.Registers.H)
 			return 5
 		},
 	}
@@ -513,7 +494,7 @@ func STA(addr uint16) Instruction {
 		Name: fmt.Sprintf("STA 0x%04x", addr),
 		Size: 3,
 		Execute: func(m *CPU) int {
-			m.Memory[addr] = m.Registers.A
+			m.Write(addr, m.Registers.A)
 			return 4
 		},
 	}
@@ -529,7 +510,7 @@ func STAX(rp byte) Instruction {
 			if sp != nil {
 				panic("STAX with SP")
 			}
-			m.Memory[uint16(*h)<<8|uint16(*l)] = m.Registers.A
+			m.Write(uint16(*h)<<8|uint16(*l), m.Registers.A)
 			return 2
 		},
 	}
@@ -541,8 +522,7 @@ func SUB(r byte) Instruction {
 		Name: fmt.Sprintf("SUB %s", RegisterCode(r)),
 		Size: 1,
 		Execute: func(m *CPU) int {
-			val := lookup8(m.selectOperand(r))
-			addDst(m, &m.Registers.A, -val, false)
+			addDst(m, &m.Registers.A, -int16(m.operand(r)), false)
 			return 2
 		},
 	}
@@ -576,10 +556,11 @@ func XTHL() Instruction {
 		Name: "XTHL",
 		Size: 1,
 		Execute: func(m *CPU) int {
-			top := m.Memory[m.SP]
-			next := m.Memory[m.SP+1]
-			m.Memory[m.SP], m.Registers.L = m.Registers.L, top
-			m.Memory[m.SP+1], m.Registers.H = m.Registers.H, next
+			top := m.Read(m.SP)
+			next := m.Read(m.SP + 1)
+			m.Write(m.SP, m.Registers.L)
+			m.Write(m.SP+1, m.Registers.H)
+			m.Registers.L, m.Registers.H = top, next
 			return 5
 		},
 	}
@@ -605,7 +586,7 @@ func XRA(r byte) Instruction {
 		Name: fmt.Sprintf("XRA %s", RegisterCode(r)),
 		Size: 1,
 		Execute: func(m *CPU) int {
-			m.Registers.A ^= byte(lookup8(m.selectOperand(r)))
+			m.Registers.A ^= m.operand(r)
 			m.setZSPC(int16(m.Registers.A))
 			m.PSW.C = false
 			return 2
@@ -619,8 +600,8 @@ func LHLD(addr uint16) Instruction {
 		Name: fmt.Sprintf("LHLD 0x%04x", addr),
 		Size: 3,
 		Execute: func(m *CPU) int {
-			m.Registers.L = m.Memory[addr]
-			m.Registers.H = m.Memory[addr+1]
+			m.Registers.L = m.Read(addr)
+			m.Registers.H = m.Read(addr + 1)
 			return 5
 		},
 		Encode: func(out []byte) {
@@ -635,14 +616,13 @@ func INR(r byte) Instruction {
 		Name: fmt.Sprintf("INR %s", RegisterCode(r)),
 		Size: 1,
 		Execute: func(m *CPU) int {
-			reg, mem := m.selectOperand(r)
-			if mem != nil {
-				addDst(m, &mem[0], 1, false)
+			v := m.operand(r)
+			addDst(m, &v, 1, false)
+			m.setOperand(r, v)
+			if r == RegisterSelMemory {
 				return 3
-			} else {
-				addDst(m, reg, 1, false)
-				return 1
 			}
+			return 1
 		},
 	}
 }
@@ -669,7 +649,7 @@ func LDA(addr uint16) Instruction {
 	return Instruction{
 		Name:    fmt.Sprintf("LDA 0x%04x", addr),
 		Size:    3,
-		Execute: func(m *CPU) int { m.Registers.A = m.Memory[addr]; return 4 },
+		Execute: func(m *CPU) int { m.Registers.A = m.Read(addr); return 4 },
 		Encode:  func(out []byte) { out[0], out[1], out[2] = 0x3A, byte(addr&0xFF), byte(addr>>8) },
 	}
 }
@@ -684,7 +664,7 @@ func LDAX(rp byte) Instruction {
 			if sp != nil {
 				panic("LDAX with SP")
 			}
-			m.Registers.A = m.Memory[uint16(*h)<<8|uint16(*l)]
+			m.Registers.A = m.Read(uint16(*h)<<8 | uint16(*l))
 			return 2
 		},
 	}
@@ -771,20 +751,8 @@ func MOV(dst byte, src byte) Instruction {
 		Name: fmt.Sprintf("MOV %s, %s", RegisterCode(dst), RegisterCode(src)),
 		Size: 1,
 		Execute: func(m *CPU) int {
-			srcR, srcMem := m.selectOperand(src)
-			var val byte
-			if srcMem != nil {
-				val = srcMem[0]
-			} else {
-				val = *srcR
-			}
-			dstR, dstMem := m.selectOperand(dst)
-			if dstMem != nil {
-				dstMem[0] = val
-			} else {
-				*dstR = val
-			}
-			if srcMem != nil || dstMem != nil {
+			m.setOperand(dst, m.operand(src))
+			if src == RegisterSelMemory || dst == RegisterSelMemory {
 				return 2
 			}
 			return 1
@@ -801,14 +769,11 @@ func MVI(dst byte, data byte) Instruction {
 		Name: fmt.Sprintf("MVI %s, 0x%02x", RegisterCode(dst), data),
 		Size: 2,
 		Execute: func(m *CPU) int {
-			dstR, dstMem := m.selectOperand(dst)
-			if dstMem != nil {
-				dstMem[0] = data
+			m.setOperand(dst, data)
+			if dst == RegisterSelMemory {
 				return 3
-			} else {
-				*dstR = data
-				return 2
 			}
+			return 2
 		},
 		Encode: func(out []byte) { out[0], out[1] = 0x06|(dst<<3), data },
 	}
@@ -820,14 +785,8 @@ func ORA(r byte) Instruction {
 		Name: fmt.Sprintf("ORA %s", RegisterCode(r)),
 		Size: 1,
 		Execute: func(m *CPU) int {
-			r, mem := m.selectOperand(r)
-			if mem != nil {
-				orA(m, mem[0])
-				return 2
-			} else {
-				orA(m, *r)
-				return 2
-			}
+			orA(m, m.operand(r))
+			return 2
 		},
 	}
 }
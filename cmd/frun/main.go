@@ -0,0 +1,363 @@
+// Command frun runs the simulator without UI.
+//
+// It boots the bootloader and monitor ROMs, loads a program (.rks, .hex or .bin), runs it for a number of cycles
+// or frames, or until a condition is met, and writes the display screenshots:
+//
+//	frun -frames 300 -png rain.png testdata/progs/rain.rks
+//	frun -cycles 50000000 -until pc=0xc800 -keys keys.txt -png out.png -every 2000000 prog.rks
+//	frun -frames 600 -record rain.gif testdata/progs/rain.rks
+//	frun -frames 600 -keys keys.txt -movie session.movie prog.rks
+//	frun -replay session.movie -verify prog.rks
+//
+// Without a program the monitor is started.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"rmazur.io/fahivets"
+	"rmazur.io/fahivets/arch"
+	"rmazur.io/fahivets/internal/scenario"
+)
+
+// frameCycles is the number of cycles in a frame at 60 Hz.
+const frameCycles = fahivets.ClockFrequency / 60
+
+type conditions []scenario.Condition
+
+func (c *conditions) String() string { return fmt.Sprint(*c) }
+
+func (c *conditions) Set(s string) error {
+	cond, err := scenario.ParseCondition(s)
+	if err != nil {
+		return err
+	}
+	*c = append(*c, cond)
+	return nil
+}
+
+var (
+	bootloaderPath = flag.String("bootloader", "testdata/progs/bootloader.rom", "bootloader ROM file")
+	monitorPath    = flag.String("monitor", "testdata/progs/monitor.rom", "monitor ROM file")
+	loadAddr       = flag.String("load", "0", "load address of a .bin program")
+	startAddr      = flag.String("start", "", "start address of the program (defaults to the load address, or to the start record of a .hex program)")
+	profileName    = flag.String("profile", fahivets.ProfileStandard.Name, "machine profile: "+strings.Join(fahivets.Profiles(), ", "))
+
+	cycles = flag.Uint64("cycles", 0, "number of cycles to run, cannot be combined with -frames")
+	frames = flag.Uint64("frames", 0, "number of frames to run (60 per second), cannot be combined with -cycles")
+	until  conditions
+
+	typeText = flag.String("type", "", "text to type after the program starts (Go escapes like \\n are supported)")
+	typeAt   = flag.Uint64("type-at", 0, "cycle to start typing the -type text at (the monitor needs about 1000000 cycles to start reading keys)")
+	keysPath = flag.String("keys", "", "key script file")
+
+	pngPath = flag.String("png", "", "write the final screenshot to the PNG file")
+	every   = flag.Uint64("every", 0, "write a screenshot every number of cycles, the cycle is added to the -png file name")
+
+	recordPath = flag.String("record", "", "record the session to the animated .gif or .png (APNG) file")
+	recordRate = flag.Int("record-rate", 50, "frames per second of the emulated time to record")
+
+	moviePath  = flag.String("movie", "", "record the keyboard input to the movie file")
+	movieRate  = flag.Int("movie-rate", 10, "display hashes per second of the emulated time stored in the -movie file")
+	replayPath = flag.String("replay", "", "replay the keyboard input from the movie file, it runs until the movie ends by default")
+	verify     = flag.Bool("verify", false, "fail if the replayed display differs from the movie")
+)
+
+func main() {
+	flag.Var(&until, "until", "stop when the condition is met: pc=ADDR, sp=ADDR, a=VALUE (any register), mem[ADDR]=VALUE, text=TEXT on the screen; can be repeated")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [program.rks|program.hex|program.bin]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(0)
+
+	if err := run(flag.Arg(0)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+var errNotMet = errors.New("the condition is not met")
+
+func run(programPath string) error {
+	var replay *fahivets.Movie
+	if *replayPath != "" {
+		f, err := os.Open(*replayPath)
+		if err != nil {
+			return err
+		}
+		replay, err = fahivets.ReadMovie(f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", *replayPath, err)
+		}
+	} else if *verify {
+		return fmt.Errorf("-verify requires -replay")
+	}
+
+	if *cycles != 0 && *frames != 0 {
+		return fmt.Errorf("specify either -cycles or -frames")
+	}
+	limit := *cycles + *frames*frameCycles
+	if limit == 0 {
+		switch {
+		case replay != nil:
+			limit = replay.Length
+		case len(until) == 0:
+			return fmt.Errorf("specify -cycles, -frames or -until")
+		default:
+			limit = ^uint64(0)
+		}
+	}
+	if *every != 0 && *pngPath == "" {
+		return fmt.Errorf("-every requires -png")
+	}
+	if ext := strings.ToLower(filepath.Ext(*recordPath)); *recordPath != "" && ext != ".gif" && ext != ".png" {
+		return fmt.Errorf("unknown recording format %q", ext)
+	}
+
+	profile, err := fahivets.LookupProfile(*profileName)
+	if err != nil {
+		return err
+	}
+	m := fahivets.NewComputerProfile(profile)
+	if err := boot(m); err != nil {
+		return err
+	}
+	var program []byte
+	if programPath != "" {
+		if program, err = load(m, programPath); err != nil {
+			return err
+		}
+	} else {
+		m.CPU.PC = uint16(arch.MemoryMapping(arch.MemROMExtra12K))
+	}
+
+	start := m.CPU.Cycles
+	var script script
+	if *keysPath != "" {
+		data, err := os.ReadFile(*keysPath)
+		if err != nil {
+			return err
+		}
+		if script, err = parseScript(string(data)); err != nil {
+			return fmt.Errorf("%s: %w", *keysPath, err)
+		}
+	}
+	if *typeText != "" {
+		text, err := strconv.Unquote(`"` + strings.ReplaceAll(*typeText, `"`, `\"`) + `"`)
+		if err != nil {
+			return fmt.Errorf("bad -type text: %w", err)
+		}
+		script = append(script, scriptAction{at: *typeAt, typeText: text})
+	}
+	scriptErr := script.schedule(m, start)
+	var rec *fahivets.Recorder
+	if *recordPath != "" {
+		rec = m.Record(*recordRate)
+	}
+	var movieRec *fahivets.MovieRecorder
+	if *moviePath != "" {
+		movieRec = m.RecordMovie(program, *movieRate)
+	}
+	var player *fahivets.MoviePlayer
+	if replay != nil {
+		if player, err = m.PlayMovie(replay, program); err != nil {
+			return err
+		}
+	}
+
+	nextShot := start + *every
+	nextFrame := start
+	for {
+		elapsed := m.CPU.Cycles - start
+		frame := m.CPU.Cycles >= nextFrame
+		if frame {
+			nextFrame += frameCycles
+		}
+		if met := until.met(m, frame); met != "" {
+			log.Printf("stopped at cycle %d: %s", elapsed, met)
+			break
+		}
+		if elapsed >= limit {
+			log.Printf("stopped at cycle %d", elapsed)
+			if len(until) > 0 {
+				return finish(m, rec, movieRec, errNotMet)
+			}
+			break
+		}
+		if *every != 0 && m.CPU.Cycles >= nextShot {
+			if err := screenshot(m, shotPath(*pngPath, elapsed)); err != nil {
+				return finish(m, rec, movieRec, err)
+			}
+			nextShot += *every
+		}
+		if _, _, err := m.Step(); err != nil {
+			return finish(m, rec, movieRec, err)
+		}
+		if *scriptErr != nil {
+			return finish(m, rec, movieRec, *scriptErr)
+		}
+	}
+	if player != nil && m.CPU.Cycles >= player.End() {
+		if err := player.Verify(); err != nil {
+			if *verify {
+				return finish(m, rec, movieRec, err)
+			}
+			log.Println(err)
+		} else {
+			log.Println("the replay matches the movie")
+		}
+	}
+	return finish(m, rec, movieRec, nil)
+}
+
+// finish writes the final screenshot and the recordings, and reports the CPU state. The recordings are written
+// for the failed runs too, as they are the ones to reproduce.
+func finish(m *fahivets.Computer, rec *fahivets.Recorder, movieRec *fahivets.MovieRecorder, err error) error {
+	log.Println(&m.CPU)
+	if n := m.Wiring().Unsettled(); n > 0 {
+		log.Printf("the IO pins did not settle %d times, the devices oscillate", n)
+	}
+	if *pngPath != "" {
+		if shotErr := screenshot(m, *pngPath); shotErr != nil {
+			err = errors.Join(err, shotErr)
+		}
+	}
+	if rec != nil {
+		rec.Stop()
+		if recErr := writeRecording(rec, *recordPath); recErr != nil {
+			err = errors.Join(err, recErr)
+		}
+	}
+	if movieRec != nil {
+		if movieErr := writeMovie(movieRec.Stop(), *moviePath); movieErr != nil {
+			err = errors.Join(err, movieErr)
+		}
+	}
+	return err
+}
+
+func boot(m *fahivets.Computer) error {
+	bootloader, err := os.ReadFile(*bootloaderPath)
+	if err != nil {
+		return err
+	}
+	monitor, err := os.ReadFile(*monitorPath)
+	if err != nil {
+		return err
+	}
+	romStart := arch.MemoryMapping(arch.MemROM2K)
+	copy(m.CPU.Memory[romStart:], bootloader)
+	copy(m.CPU.Memory[arch.MemoryMapping(arch.MemROMExtra12K):], monitor)
+	m.CPU.PC = uint16(romStart)
+
+	// Make sure bootloader is executed.
+	for range 16_000 {
+		if _, _, err := m.Step(); err != nil {
+			return fmt.Errorf("boot: %w", err)
+		}
+	}
+	return nil
+}
+
+// load loads the program and returns the file content.
+func load(m *fahivets.Computer, path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var program fahivets.HexData
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".rks":
+		var rks fahivets.RksData
+		rks, err = fahivets.ReadRks(bytes.NewReader(raw))
+		program = rks.AsHex()
+	case ".hex":
+		program, err = fahivets.ReadHex(bytes.NewReader(raw))
+	case ".bin":
+		var addr uint64
+		addr, err = strconv.ParseUint(*loadAddr, 0, 16)
+		if err == nil && int(addr)+len(raw) > len(m.CPU.Memory) {
+			err = fmt.Errorf("program does not fit into memory")
+		}
+		program = fahivets.RksData{StartAddress: uint16(addr), Content: raw}.AsHex()
+	default:
+		err = fmt.Errorf("unknown program format %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	start := program.Start
+	if *startAddr != "" {
+		addr, err := strconv.ParseUint(*startAddr, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad start address: %w", err)
+		}
+		start = uint16(addr)
+	}
+	program.Load(m.CPU.Memory[:])
+	m.CPU.Exec(arch.JMP(start))
+	return raw, nil
+}
+
+func shotPath(path string, cycle uint64) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%010d%s", strings.TrimSuffix(path, ext), cycle, ext)
+}
+
+func screenshot(m *fahivets.Computer, path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, m.Display.Image()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeRecording(rec *fahivets.Recorder, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	write := rec.WriteAPNG
+	if strings.ToLower(filepath.Ext(path)) == ".gif" {
+		write = rec.WriteGIF
+	}
+	if err := write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	log.Printf("recorded %d frames to %s", rec.Frames(), path)
+	return f.Close()
+}
+
+func writeMovie(movie *fahivets.Movie, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := movie.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	log.Printf("recorded %d key events to %s", len(movie.Keys), path)
+	return f.Close()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"rmazur.io/fahivets"
+	"rmazur.io/fahivets/arch"
+	"rmazur.io/fahivets/devices"
+	"rmazur.io/fahivets/internal/testutil"
+)
+
+const rainPath = "../../testdata/progs/rain.rks"
+
+// setFlags sets the command line flags for the test and restores their defaults when the test ends.
+func setFlags(t *testing.T, values map[string]string, conditions ...string) {
+	t.Helper()
+	values["bootloader"] = "../../testdata/progs/bootloader.rom"
+	values["monitor"] = "../../testdata/progs/monitor.rom"
+	for name, v := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			t.Fatalf("unknown flag %s", name)
+		}
+		if err := f.Value.Set(v); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = f.Value.Set(f.DefValue) })
+	}
+	t.Cleanup(func() { until = nil })
+	for _, c := range conditions {
+		if err := until.Set(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.SetOutput(testutil.NewTestLogWriter(t))
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+func TestRun(t *testing.T) {
+	t.Run("until", func(t *testing.T) {
+		png := filepath.Join(t.TempDir(), "rain.png")
+		// Rain polls the keyboard at 0x0084 once the title is shown.
+		setFlags(t, map[string]string{"frames": "60", "png": png}, "pc=0x0084")
+		if err := run(rainPath); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(png); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("until/not met", func(t *testing.T) {
+		// The instructions are shown only after the key 1 is pressed.
+		setFlags(t, map[string]string{"frames": "30"}, "pc=0x0096")
+		if err := run(rainPath); !errors.Is(err, errNotMet) {
+			t.Errorf("got error %v; want %v", err, errNotMet)
+		}
+	})
+
+	t.Run("until/not met/movie", func(t *testing.T) {
+		movie := filepath.Join(t.TempDir(), "rain.movie")
+		setFlags(t, map[string]string{"frames": "30", "movie": movie}, "pc=0x0096")
+		if err := run(rainPath); !errors.Is(err, errNotMet) {
+			t.Errorf("got error %v; want %v", err, errNotMet)
+		}
+		if _, err := os.Stat(movie); err != nil {
+			t.Errorf("the movie of the failed run is not written: %s", err)
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		keys := filepath.Join(t.TempDir(), "keys.txt")
+		if err := os.WriteFile(keys, []byte("400000 press 4,10 40000\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		setFlags(t, map[string]string{"frames": "60", "keys": keys}, "pc=0x0096")
+		if err := run(rainPath); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for _, tc := range []struct {
+		name   string
+		values map[string]string
+	}{
+		{name: "cycles and frames", values: map[string]string{"cycles": "1000", "frames": "1"}},
+		{name: "no limit", values: map[string]string{}},
+		{name: "every without png", values: map[string]string{"frames": "1", "every": "1000"}},
+		{name: "verify without replay", values: map[string]string{"frames": "1", "verify": "true"}},
+		{name: "recording format", values: map[string]string{"frames": "1", "record": "out.mp4"}},
+	} {
+		t.Run("flags/"+tc.name, func(t *testing.T) {
+			setFlags(t, tc.values)
+			if err := run(rainPath); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		values  map[string]string
+		wantPC  uint16
+		wantErr bool
+	}{
+		{name: "rks", path: rainPath, wantPC: 0},
+		{name: "hex", path: write("prog.hex", ":03100000C300101A\n:00000001FF\n"), wantPC: 0x1000},
+		{name: "hex/start record", path: write("start.hex", ":03100000C300101A\n:0400000500001010D7\n:00000001FF\n"), wantPC: 0x1010},
+		{name: "bin", path: write("prog.bin", "\xc3\x00\x20"), values: map[string]string{"load": "0x2000"}, wantPC: 0x2000},
+		{name: "bin/start", path: write("start.bin", "\x00\x00"), values: map[string]string{"load": "0x2000", "start": "0x2001"}, wantPC: 0x2001},
+		{name: "bin/too long", path: write("long.bin", "\x00\x00"), values: map[string]string{"load": "0xffff"}, wantErr: true},
+		{name: "unknown format", path: write("prog.com", "\x00"), wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.values == nil {
+				tc.values = map[string]string{}
+			}
+			setFlags(t, tc.values)
+			m := fahivets.NewComputer()
+			// The program is loaded after the boot when the CPU runs the ROM.
+			m.CPU.PC = uint16(arch.MemoryMapping(arch.MemROM2K))
+			_, err := load(m, tc.path)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.CPU.PC != tc.wantPC {
+				t.Errorf("got PC 0x%04x; want 0x%04x", m.CPU.PC, tc.wantPC)
+			}
+		})
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	s, err := parseScript(`
+# Comment.
+100 type "A\n"
+200 type hello world
+0x300 press НР+4,10 5000
+400 press РУС
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := script{
+		{at: 100, typeText: "A\n"},
+		{at: 200, typeText: "hello world"},
+		{at: 0x300, press: devices.KeyBinding{Key: devices.MatrixKeyCode(4, 10), Shift: true}, hold: 5000},
+		{at: 400, press: devices.KeyBinding{Key: devices.KeyRus}},
+	}
+	if len(s) != len(want) {
+		t.Fatalf("got %d actions; want %d", len(s), len(want))
+	}
+	for i := range want {
+		if s[i] != want[i] {
+			t.Errorf("action %d: got %+v; want %+v", i, s[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{
+		"100 type",
+		"x type A",
+		"100 jump A",
+		"100 press 4,10 1 2",
+		"100 press Q",
+		"100 press 4,10 long",
+	} {
+		if _, err := parseScript(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
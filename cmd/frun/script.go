@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"rmazur.io/fahivets"
+	"rmazur.io/fahivets/devices"
+	"rmazur.io/fahivets/internal/scenario"
+)
+
+// met returns the first condition that is met. The screen conditions are checked only at the frame boundaries.
+func (cs conditions) met(m *fahivets.Computer, frame bool) string {
+	for _, c := range cs {
+		if (frame || !c.Screen()) && c.Met(m) {
+			return c.String()
+		}
+	}
+	return ""
+}
+
+// script is a list of keyboard actions. Every line of the script file is one of
+//
+//	CYCLE type TEXT
+//	CYCLE press KEY [HOLD]
+//
+// CYCLE is the number of cycles after the program start. TEXT is typed with Keyboard.Type, it can be quoted
+// to use Go escapes. KEY is a keymap target ("row,col", "НР", "РУС", "НР+row,col"), held for HOLD cycles
+// (the keyboard typing hold time by default). Empty lines and lines starting with # are ignored.
+type script []scriptAction
+
+type scriptAction struct {
+	at       uint64
+	typeText string
+	press    devices.KeyBinding
+	hold     uint64
+}
+
+func parseScript(text string) (script, error) {
+	var res script
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected CYCLE ACTION ARGS", line)
+		}
+		var (
+			a   scriptAction
+			err error
+		)
+		if a.at, err = strconv.ParseUint(fields[0], 0, 64); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		switch fields[1] {
+		case "type":
+			// Keep the spaces of the text.
+			_, rest, _ := strings.Cut(strings.TrimSpace(scanner.Text()), fields[1])
+			a.typeText = strings.TrimSpace(rest)
+			if strings.HasPrefix(a.typeText, `"`) {
+				if a.typeText, err = strconv.Unquote(a.typeText); err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+			}
+		case "press":
+			if len(fields) > 4 {
+				return nil, fmt.Errorf("line %d: expected CYCLE press KEY [HOLD]", line)
+			}
+			if a.press, err = devices.ParseKeyBinding(fields[2]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if len(fields) == 4 {
+				if a.hold, err = strconv.ParseUint(fields[3], 0, 64); err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", line, fields[1])
+		}
+		res = append(res, a)
+	}
+	return res, scanner.Err()
+}
+
+// schedule registers the actions in the computer scheduler. The returned error is set if typing fails.
+func (s script) schedule(m *fahivets.Computer, start uint64) *error {
+	var err error
+	kb := m.Keyboard
+	for _, a := range s {
+		m.Scheduler.At(start+a.at, func() {
+			if a.typeText != "" {
+				if _, typeErr := kb.Type(a.typeText); typeErr != nil {
+					err = typeErr
+				}
+				return
+			}
+			hold := a.hold
+			if hold == 0 {
+				hold = kb.Typing.Hold
+			}
+			scenario.PressKey(m, a.press, hold)
+		})
+	}
+	return &err
+}
@@ -0,0 +1,59 @@
+// Plays the samples generated by the simulated speaker.
+// Samples arrive in chunks after every simulated frame and are kept in a ring buffer.
+class SpeakerProcessor extends AudioWorkletProcessor {
+  constructor() {
+    super();
+    this.ring = new Float32Array(sampleRate);
+    this.readPos = 0;
+    this.size = 0;
+    this.playing = false;
+
+    // Collect a couple of frames before starting the playback to survive the jitter of the frame rate.
+    this.startSize = Math.round(sampleRate * 0.03);
+    // Drop old samples instead of accumulating the latency when the simulation runs faster than the audio.
+    this.maxSize = Math.round(sampleRate * 0.1);
+
+    this.port.onmessage = (e) => this.push(e.data);
+  }
+
+  push(samples) {
+    const ring = this.ring;
+    for (let i = 0; i < samples.length; i++) {
+      ring[(this.readPos + this.size) % ring.length] = samples[i];
+      if (this.size < ring.length) {
+        this.size++;
+      } else {
+        this.readPos = (this.readPos + 1) % ring.length;
+      }
+    }
+    if (this.size > this.maxSize) {
+      const drop = this.size - this.startSize;
+      this.readPos = (this.readPos + drop) % ring.length;
+      this.size -= drop;
+    }
+  }
+
+  process(inputs, outputs) {
+    const output = outputs[0];
+    const channel = output[0];
+    if (!this.playing && this.size >= this.startSize) {
+      this.playing = true;
+    }
+    for (let i = 0; i < channel.length; i++) {
+      if (this.playing && this.size > 0) {
+        channel[i] = this.ring[this.readPos];
+        this.readPos = (this.readPos + 1) % this.ring.length;
+        this.size--;
+      } else {
+        this.playing = false;
+        channel[i] = 0;
+      }
+    }
+    for (let c = 1; c < output.length; c++) {
+      output[c].set(channel);
+    }
+    return true;
+  }
+}
+
+registerProcessor("speaker", SpeakerProcessor);
@@ -2,6 +2,469 @@
 
 package main
 
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+	"rmazur.io/fahivets"
+	"rmazur.io/fahivets/devices"
+)
+
+var (
+	renderMode = flag.String("render", "braille", "terminal rendering mode: braille or halfblock")
+	keymapName = flag.String("keymap", "ukrainian", "keymap: "+strings.Join(devices.Keymaps(), ", "))
+	speed      = flag.String("speed", "1", "speed multiplier of the simulation, like 0.5 or 2, or turbo")
+
+	// The terminal cells are coarse, there's no point in scaling the display.
+	displayOptions = devices.PostOptions{Palette: devices.MonoWhite, Scale: 1}
+)
+
+func init() {
+	for _, opt := range []struct{ name, usage string }{
+		{"palette", "monochrome display palette: amber, green, white"},
+		{"scale", "display scale before fitting it into the terminal"},
+		{"scanlines", "darken every other line by the factor from 0 to 1"},
+		{"persistence", "phosphor persistence factor from 0 to 1"},
+	} {
+		flag.Func(opt.name, opt.usage, func(v string) error { return displayOptions.Set(opt.name, v) })
+	}
+	for _, opt := range []struct{ name, usage string }{
+		{"aspect", "stretch the display to 4:3"},
+		{"smooth", "smooth the scaled display"},
+	} {
+		flag.BoolFunc(opt.name, opt.usage, func(v string) error { return displayOptions.Set(opt.name, v) })
+	}
+	flag.IntVar(&runOptions.FrameRate, "refresh", runOptions.FrameRate, "frames per second of the simulated display: 50 or 60")
+	flag.StringVar(&movieOptions.Record, "movie", "", "record the keyboard input to the movie file, it is written on exit")
+	flag.StringVar(&movieOptions.Replay, "replay", "", "replay the keyboard input from the movie file instead of the typed keys")
+	flag.IntVar(&movieOptions.FrameRate, "movie-rate", movieOptions.FrameRate, "display hashes per second of the emulated time stored in the movie")
+	flag.BoolFunc("skip-lag", "slow down the simulation instead of catching up when the host falls behind", func(string) error {
+		runOptions.Lag = fahivets.Skip
+		return nil
+	})
+}
+
+// makeUiWorld creates the terminal UI. The display is rendered with Unicode characters, the keys are read
+// from the terminal input in the raw mode. Ctrl+R presses РУС, Ctrl+L presses НР, Ctrl+P pauses, Ctrl+C exits.
+// The keys are ignored while a movie is replayed.
 func makeUiWorld() UiWorld {
-	panic("TODO: Implement non-web version")
+	flag.Parse()
+	if *renderMode != "braille" && *renderMode != "halfblock" {
+		log.Fatalf("unknown render mode %q", *renderMode)
+	}
+	if runOptions.FrameRate != 50 && runOptions.FrameRate != 60 {
+		log.Fatalf("unsupported refresh rate %d", runOptions.FrameRate)
+	}
+	if movieOptions.Record != "" && movieOptions.Replay != "" {
+		log.Fatal("-movie and -replay cannot be used together")
+	}
+	w := &termUiWorld{
+		in:     os.Stdin,
+		out:    bufio.NewWriterSize(os.Stdout, 64*1024),
+		outFd:  int(os.Stdout.Fd()),
+		mode:   *renderMode,
+		events: make(chan func(), 256),
+		exit:   make(chan struct{}),
+	}
+	_, _ = w.out.WriteString("\x1b[?1049h\x1b[?25l\x1b[2J")
+	return w
+}
+
+type termUiWorld struct {
+	in    io.Reader
+	out   *bufio.Writer
+	outFd int
+	mode  string
+
+	// Events are executed in the routine that runs the simulation.
+	events chan func()
+	// exit is closed when the user quits.
+	exit chan struct{}
+
+	prev     [][]string
+	rendered time.Time
+	speaker  *devices.Speaker
+	keys     *termKeys
+	control  RunController
+	restore  func()
+}
+
+func (w *termUiWorld) Poll() {
+	w.drainEvents()
+	if w.speaker != nil {
+		// The terminal has no sound, drop the samples.
+		w.speaker.Pull(make([]float32, w.speaker.Buffered()))
+	}
+}
+
+func (w *termUiWorld) PresentFrame(img *image.RGBA, _ []image.Rectangle) {
+	// The turbo mode produces more frames than the terminal can show.
+	now := time.Now()
+	if now.Sub(w.rendered) < time.Second/60 {
+		return
+	}
+	w.rendered = now
+	// The terminal output has its own diff of the cells.
+	w.render(img)
+}
+
+func (w *termUiWorld) ConfigureDisplay(configure func(opts devices.PostOptions)) {
+	configure(displayOptions)
+}
+
+// ControlRun applies the -speed flag. It must be called before ConnectKeyboard, which handles the pause key.
+func (w *termUiWorld) ControlRun(c RunController) {
+	v, err := parseSpeed(*speed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.SetSpeed(v)
+	w.control = c
+}
+
+func (w *termUiWorld) ConnectAudio(speaker *devices.Speaker) {
+	w.events <- func() { w.speaker = speaker }
+}
+
+func (w *termUiWorld) ConnectKeyboard(keyboard *devices.Keyboard) {
+	km, err := devices.BuiltinKeymap(*keymapName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys := newTermKeys(devices.NewKeymapInput(keyboard, km), keyboard.Scheduler(), keyboard.Typing)
+
+	w.restore = func() {}
+	if f, ok := w.in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		state, err := term.MakeRaw(int(f.Fd()))
+		if err != nil {
+			log.Fatal(err)
+		}
+		w.restore = func() { _ = term.Restore(int(f.Fd()), state) }
+	}
+	w.events <- func() { w.keys = keys }
+
+	go func() {
+		var (
+			buf  = make([]byte, 256)
+			rest []byte
+		)
+		for {
+			n, err := w.in.Read(buf)
+			if err != nil {
+				w.control.Resume()
+				close(w.exit)
+				return
+			}
+			var parsed []termKey
+			parsed, rest = parseTermKeys(append(rest, buf[:n]...))
+			for _, k := range parsed {
+				switch k.code {
+				case "ControlC":
+					// The paused simulation does not present the frames.
+					w.control.Resume()
+					close(w.exit)
+					return
+				case "ControlP":
+					// The paused simulation does not poll the events, so it's toggled right here.
+					if w.control.Paused() {
+						w.control.Resume()
+					} else {
+						w.control.Pause()
+					}
+					continue
+				}
+				if w.control.Paused() || movieOptions.Replay != "" {
+					continue
+				}
+				// Drop the keys if the simulation falls behind, the reader must not block on the events.
+				select {
+				case w.events <- func() { keys.press(k) }:
+				default:
+				}
+			}
+		}
+	}()
+}
+
+func (w *termUiWorld) drainEvents() {
+	for {
+		select {
+		case e := <-w.events:
+			e()
+		default:
+			return
+		}
+	}
+}
+
+func (w *termUiWorld) Done() <-chan struct{} { return w.exit }
+
+func (w *termUiWorld) Close() {
+	_, _ = w.out.WriteString("\x1b[0m\x1b[?25h\x1b[?1049l")
+	_ = w.out.Flush()
+	if w.restore != nil {
+		w.restore()
+	}
+}
+
+func (w *termUiWorld) render(img image.Image) {
+	cols, rows, err := term.GetSize(w.outFd)
+	if err != nil {
+		cols, rows = 80, 24
+	}
+	cells := renderCells(img, cols, rows, w.mode)
+	if len(w.prev) != len(cells) || len(w.prev) > 0 && len(w.prev[0]) != len(cells[0]) {
+		// The terminal is resized.
+		_, _ = w.out.WriteString("\x1b[0m\x1b[2J")
+		w.prev = nil
+	}
+	writeDiff(w.out, w.prev, cells)
+	w.prev = cells
+	_ = w.out.Flush()
+}
+
+// renderCells converts the image into the terminal cells fitting into cols x rows.
+// The braille mode draws 2x4 monochrome dots per cell, the halfblock mode draws 1x2 colored pixels per cell.
+// Both modes have square pixels on terminals with 1:2 cells.
+func renderCells(img image.Image, cols, rows int, mode string) [][]string {
+	cw, ch := 2, 4
+	if mode == "halfblock" {
+		cw, ch = 1, 2
+	}
+	b := img.Bounds()
+	scale := min(float64(cols*cw)/float64(b.Dx()), float64(rows*ch)/float64(b.Dy()))
+	pw, ph := int(float64(b.Dx())*scale), int(float64(b.Dy())*scale)
+	if pw == 0 || ph == 0 {
+		return nil
+	}
+
+	// pixel returns the average color of the source area under the target pixel, and whether any of its pixels is lit.
+	pixel := func(x, y int) (r, g, bl uint32, lit bool) {
+		x0, y0 := b.Min.X+int(float64(x)/scale), b.Min.Y+int(float64(y)/scale)
+		x1, y1 := max(b.Min.X+int(float64(x+1)/scale), x0+1), max(b.Min.Y+int(float64(y+1)/scale), y0+1)
+		var n uint32
+		for sy := y0; sy < min(y1, b.Max.Y); sy++ {
+			for sx := x0; sx < min(x1, b.Max.X); sx++ {
+				pr, pg, pb, _ := img.At(sx, sy).RGBA()
+				r, g, bl, n = r+pr>>8, g+pg>>8, bl+pb>>8, n+1
+				lit = lit || pr+pg+pb >= 3*0x8000
+			}
+		}
+		if n > 0 {
+			r, g, bl = r/n, g/n, bl/n
+		}
+		return
+	}
+
+	res := make([][]string, (ph+ch-1)/ch)
+	for cy := range res {
+		res[cy] = make([]string, (pw+cw-1)/cw)
+		for cx := range res[cy] {
+			x, y := cx*cw, cy*ch
+			if mode == "halfblock" {
+				tr, tg, tb, _ := pixel(x, y)
+				br, bg, bb, _ := pixel(x, y+1)
+				res[cy][cx] = fmt.Sprintf("\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", tr, tg, tb, br, bg, bb)
+				continue
+			}
+			var dots rune
+			for i, bit := range brailleDots {
+				if px, py := x+i%2, y+i/2; px < pw && py < ph {
+					if _, _, _, lit := pixel(px, py); lit {
+						dots |= bit
+					}
+				}
+			}
+			res[cy][cx] = string(0x2800 + dots)
+		}
+	}
+	return res
+}
+
+// brailleDots are the bits of the braille pattern dots, row by row.
+var brailleDots = [8]rune{0x01, 0x08, 0x02, 0x10, 0x04, 0x20, 0x40, 0x80}
+
+// writeDiff writes the cells that differ from the previous frame.
+func writeDiff(out io.Writer, prev, cur [][]string) {
+	cursorX, cursorY := -1, -1
+	for y, row := range cur {
+		for x, cell := range row {
+			if prev != nil && prev[y][x] == cell {
+				continue
+			}
+			if x != cursorX || y != cursorY {
+				_, _ = fmt.Fprintf(out, "\x1b[%d;%dH", y+1, x+1)
+			}
+			_, _ = io.WriteString(out, cell)
+			cursorX, cursorY = x+1, y
+		}
+	}
+}
+
+// termKey is a key read from the terminal. Codes follow the browser key codes used by the keymaps.
+type termKey struct {
+	code, char string
+	// hotkey is pressed bypassing the keymap.
+	hotkey *devices.KeyBinding
+}
+
+var termSequences = map[string]string{
+	"[A": "ArrowUp", "[B": "ArrowDown", "[C": "ArrowRight", "[D": "ArrowLeft",
+	"[H": "Home", "[1~": "Home", "[7~": "Home", "OH": "Home",
+	"OP": "F1", "OQ": "F2", "OR": "F3", "OS": "F4",
+	"[11~": "F1", "[12~": "F2", "[13~": "F3", "[14~": "F4", "[15~": "F5",
+	"[17~": "F6", "[18~": "F7", "[19~": "F8", "[20~": "F9", "[21~": "F10", "[23~": "F11", "[24~": "F12",
+}
+
+var termCodes = func() map[rune]string {
+	res := map[rune]string{' ': "Space"}
+	for r := 'a'; r <= 'z'; r++ {
+		res[r] = "Key" + strings.ToUpper(string(r))
+		res[r-'a'+'A'] = res[r]
+	}
+	for i, r := range "0123456789" {
+		res[r] = fmt.Sprint("Digit", i)
+	}
+	for i, r := range ")!@#$%^&*(" {
+		res[r] = fmt.Sprint("Digit", i)
+	}
+	for _, pair := range []string{"-_Minus", "=+Equal", "[{BracketLeft", "]}BracketRight", ";:Semicolon",
+		"'\"Quote", "\\|Backslash", ",<Comma", ".>Period", "/?Slash", "`~Backquote"} {
+		res[rune(pair[0])], res[rune(pair[1])] = pair[2:], pair[2:]
+	}
+	return res
+}()
+
+// parseTermKeys parses the terminal input. Incomplete sequences are returned to be parsed with the next input.
+func parseTermKeys(in []byte) (keys []termKey, rest []byte) {
+	for len(in) > 0 {
+		c := in[0]
+		switch {
+		case c == 0x1b:
+			if len(in) == 1 {
+				// A single ESC press.
+				return append(keys, termKey{code: "Escape", char: "Escape"}), nil
+			}
+			end := 2
+			if in[1] == '[' || in[1] == 'O' {
+				for end < len(in) && (in[end] < 0x40 || in[end] > 0x7e) {
+					end++
+				}
+				if end == len(in) {
+					return keys, in
+				}
+				end++
+			}
+			if code, ok := termSequences[string(in[1:end])]; ok {
+				keys = append(keys, termKey{code: code, char: code})
+			}
+			in = in[end:]
+			continue
+		case c == 0x03:
+			keys = append(keys, termKey{code: "ControlC"})
+		case c == 0x12:
+			keys = append(keys, termKey{code: "ControlR", hotkey: &devices.KeyBinding{Key: devices.KeyRus}})
+		case c == 0x0c:
+			keys = append(keys, termKey{code: "ControlL", hotkey: &devices.KeyBinding{Key: devices.KeyShift}})
+		case c == 0x10:
+			keys = append(keys, termKey{code: "ControlP"})
+		case c == '\r' || c == '\n':
+			keys = append(keys, termKey{code: "Enter", char: "Enter"})
+		case c == '\t':
+			keys = append(keys, termKey{code: "Tab", char: "Tab"})
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, termKey{code: "Backspace", char: "Backspace"})
+		case c < 0x20:
+			// Other control keys are not used.
+		default:
+			if !utf8.FullRune(in) {
+				return keys, in
+			}
+			r, size := utf8.DecodeRune(in)
+			if r == utf8.RuneError {
+				in = in[size:]
+				continue
+			}
+			code, ok := termCodes[r]
+			if !ok {
+				code = string(r)
+			}
+			keys = append(keys, termKey{code: code, char: string(r)})
+			in = in[size:]
+			continue
+		}
+		in = in[1:]
+	}
+	return keys, nil
+}
+
+// Terminals do not report the key releases. A key is released after a short hold, or after a longer one when
+// the terminal repeats it. Keys pressed while another one is held are queued, so the monitor sees the pauses
+// it needs between the keys. The times are counted in the CPU cycles, so they do not depend on the speed.
+const (
+	termKeyRepeatHold = fahivets.ClockFrequency * 150 / 1000
+	termKeyQueue      = 64
+)
+
+type termKeys struct {
+	input *devices.KeymapInput
+	sched *devices.Scheduler
+
+	hold, gap uint64
+	queue     []termKey
+	held      *termKey
+	release   *devices.Event
+	// busy is set while a key is held and during the gap after it.
+	busy bool
+}
+
+func newTermKeys(input *devices.KeymapInput, sched *devices.Scheduler, typing devices.Typing) *termKeys {
+	return &termKeys{input: input, sched: sched, hold: typing.Hold, gap: typing.Release}
+}
+
+func (k *termKeys) press(key termKey) {
+	if k.held != nil && k.held.code == key.code && len(k.queue) == 0 {
+		// Autorepeat.
+		k.release.Cancel()
+		k.release = k.sched.After(termKeyRepeatHold, k.releaseHeld)
+		return
+	}
+	if len(k.queue) < termKeyQueue {
+		k.queue = append(k.queue, key)
+	}
+	if !k.busy {
+		k.next()
+	}
+}
+
+// next presses the first queued key that the keymap knows.
+func (k *termKeys) next() {
+	k.busy = false
+	for len(k.queue) > 0 {
+		key := k.queue[0]
+		k.queue = k.queue[1:]
+		if key.hotkey != nil {
+			k.input.PressBinding(key.code, *key.hotkey)
+		} else if !k.input.Press(key.code, key.char) {
+			continue
+		}
+		k.held, k.busy = &key, true
+		k.release = k.sched.After(k.hold, k.releaseHeld)
+		return
+	}
+}
+
+func (k *termKeys) releaseHeld() {
+	k.input.Release(k.held.code)
+	k.held = nil
+	k.sched.After(k.gap, k.next)
 }
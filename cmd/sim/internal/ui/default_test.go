@@ -0,0 +1,199 @@
+//go:build !js
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"rmazur.io/fahivets"
+	"rmazur.io/fahivets/arch"
+	"rmazur.io/fahivets/devices"
+)
+
+func TestParseTermKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		in    string
+		codes []string
+		rest  string
+	}{
+		{name: "chars", in: "q!Й", codes: []string{"KeyQ", "Digit1", "Й"}},
+		{name: "controls", in: "\r\t\x7f\x12\x0c\x10", codes: []string{"Enter", "Tab", "Backspace", "ControlR", "ControlL", "ControlP"}},
+		{name: "sequences", in: "\x1b[A\x1bOP\x1b[24~\x1b[1;5C", codes: []string{"ArrowUp", "F1", "F12"}},
+		{name: "incomplete sequence", in: "a\x1b[2", codes: []string{"KeyA"}, rest: "\x1b[2"},
+		{name: "incomplete rune", in: "a\xd0", codes: []string{"KeyA"}, rest: "\xd0"},
+		{name: "escape", in: "\x1b", codes: []string{"Escape"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, rest := parseTermKeys([]byte(tc.in))
+			var codes []string
+			for _, k := range keys {
+				codes = append(codes, k.code)
+			}
+			if !slices.Equal(codes, tc.codes) {
+				t.Errorf("got codes %q; want %q", codes, tc.codes)
+			}
+			if string(rest) != tc.rest {
+				t.Errorf("got rest %q; want %q", rest, tc.rest)
+			}
+		})
+	}
+}
+
+func TestRenderCells(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	img.Set(0, 0, color.White)
+	img.Set(7, 7, color.White)
+
+	braille := renderCells(img, 10, 1, "braille")
+	// 8x8 image fits 1 row of braille cells with the scale 0.5.
+	if len(braille) != 1 || len(braille[0]) != 2 {
+		t.Fatalf("got braille cells %q", braille)
+	}
+	if braille[0][0] != "⠁" || braille[0][1] != "⢀" {
+		t.Errorf("got braille cells %q; want [⠁ ⢀]", braille[0])
+	}
+
+	half := renderCells(img, 8, 4, "halfblock")
+	if len(half) != 4 || len(half[0]) != 8 {
+		t.Fatalf("got %dx%d halfblock cells; want 8x4", len(half[0]), len(half))
+	}
+	if want := "\x1b[38;2;255;255;255m\x1b[48;2;0;0;0m▀"; half[0][0] != want {
+		t.Errorf("got top left cell %q; want %q", half[0][0], want)
+	}
+
+	var out bytes.Buffer
+	writeDiff(&out, nil, [][]string{{"a", "b"}, {"c", "d"}})
+	if got, want := out.String(), "\x1b[1;1Hab\x1b[2;1Hcd"; got != want {
+		t.Errorf("got full frame %q; want %q", got, want)
+	}
+	out.Reset()
+	writeDiff(&out, [][]string{{"a", "b"}, {"c", "d"}}, [][]string{{"a", "x"}, {"c", "d"}})
+	if got, want := out.String(), "\x1b[1;2Hx"; got != want {
+		t.Errorf("got diff %q; want %q", got, want)
+	}
+}
+
+func TestTermKeys(t *testing.T) {
+	var cpu arch.CPU
+	sched := devices.NewScheduler(&cpu.Cycles)
+	kb := devices.NewKeyboard(devices.NewWiring(arch.InitIoController(&cpu), &cpu.Cycles), sched)
+	km, _ := devices.BuiltinKeymap(devices.DefaultKeymap)
+	keys := newTermKeys(devices.NewKeymapInput(kb, km), sched, devices.Typing{Hold: 20_000, Release: 100_000})
+
+	// at runs the events up to the cycle, like the CPU does.
+	at := func(cycle uint64) {
+		cpu.Cycles = cycle
+		sched.Run()
+	}
+	held := func() string {
+		if keys.held == nil {
+			return ""
+		}
+		return keys.held.code
+	}
+
+	keys.press(termKey{code: "KeyA", char: "a"})
+	at(1)
+	keys.press(termKey{code: "KeyB", char: "b"})
+	if held() != "KeyA" {
+		t.Errorf("got %q held; want KeyA", held())
+	}
+	at(20_000)
+	at(40_000)
+	if held() != "" {
+		t.Errorf("got %q held during the gap", held())
+	}
+	at(120_000)
+	if held() != "KeyB" {
+		t.Errorf("got %q held; want KeyB", held())
+	}
+	// Autorepeat keeps the key held.
+	at(130_000)
+	keys.press(termKey{code: "KeyB", char: "b"})
+	at(200_000)
+	if held() != "KeyB" {
+		t.Errorf("got %q held; want KeyB", held())
+	}
+	at(130_000 + termKeyRepeatHold)
+	if held() != "" {
+		t.Errorf("got %q held; want none", held())
+	}
+}
+
+func TestTermReaderDoesNotBlock(t *testing.T) {
+	for _, paused := range []bool{false, true} {
+		m := fahivets.NewComputer()
+		if paused {
+			m.Pause()
+		}
+		// Nothing drains the events, like when the simulation is paused.
+		w := &termUiWorld{
+			in:      bytes.NewReader(append(bytes.Repeat([]byte("a"), 1000), 0x10, 0x03)),
+			events:  make(chan func(), 256),
+			exit:    make(chan struct{}),
+			control: m,
+		}
+		w.ConnectKeyboard(m.Keyboard)
+		select {
+		case <-w.exit:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("paused %t: the reader is blocked before Ctrl+C", paused)
+		}
+		if m.Paused() {
+			t.Errorf("paused %t: the simulation is paused after Ctrl+C", paused)
+		}
+	}
+}
+
+func TestMovie(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rain.movie")
+	session := func(record bool) (*fahivets.Computer, *fahivets.MovieRecorder, *fahivets.MoviePlayer) {
+		t.Helper()
+		movieOptions.Record, movieOptions.Replay = "", path
+		if record {
+			movieOptions.Record, movieOptions.Replay = path, ""
+		}
+		t.Cleanup(func() { movieOptions.Record, movieOptions.Replay = "", "" })
+
+		m := fahivets.NewComputer()
+		prepareSimulation(m)
+		rec, player, err := startMovie(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m, rec, player
+	}
+	runFor := func(m *fahivets.Computer, cycles uint64) {
+		t.Helper()
+		if err := m.RunFor(cycles); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, rec, _ := session(true)
+	runFor(m, fahivets.ClockFrequency/2)
+	digit1 := devices.MatrixKeyCode(4, 10)
+	m.Keyboard.Event(digit1, devices.KeyStateDown)
+	runFor(m, fahivets.ClockFrequency/50)
+	m.Keyboard.Event(digit1, devices.KeyStateUp)
+	runFor(m, fahivets.ClockFrequency/2)
+	if err := finishMovie(m, rec, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	m, _, player := session(false)
+	if player == nil {
+		t.Fatal("the movie is not replayed")
+	}
+	runFor(m, player.End()-m.CPU.Cycles)
+	if err := player.Verify(); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
+	"errors"
+	"fmt"
 	"image"
 	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
 
-	"golang.org/x/image/draw"
 	"rmazur.io/fahivets"
 	"rmazur.io/fahivets/arch"
 	"rmazur.io/fahivets/devices"
@@ -24,45 +30,174 @@ var (
 	m = fahivets.NewComputer()
 )
 
+// runOptions are the options of the simulation loop, the UI may change them before it starts.
+var runOptions = fahivets.RunOptions{FrameRate: 60}
+
+// movieOptions select the input movie to record or replay, the UI may change them before the simulation starts.
+var movieOptions = struct {
+	// Record is the file the keyboard input is written to when the simulation stops.
+	Record string
+	// Replay is the movie file fed to the keyboard, the user keys are ignored while it's played.
+	Replay string
+	// FrameRate is the number of the display hashes stored per second of the emulated time.
+	FrameRate int
+}{FrameRate: 10}
+
 func main() {
 	ui := makeUiWorld()
 
 	prepareSimulation(m)
+	movieRec, player, err := startMovie(m)
+	if err != nil {
+		ui.Close()
+		log.Fatal(err)
+	}
 
-	frame := m.Display.Image()
-	size := frame.Bounds()
-	frameBuf := image.NewRGBA(image.Rect(0, 0, size.Dx()*2, size.Dy()*2))
-	fillBuf(frameBuf, frame)
-
-	ui.ConsumeDisplayFrames(func() image.Image {
-		const (
-			cpuFreq     = 2_000_000 // 2 MHz
-			refreshRate = 60        // Hz
-			perFrame    = cpuFreq / refreshRate
-		)
-
-		n := 0
-		for n < perFrame {
-			_, c, err := m.Step()
-			if err != nil {
-				log.Println("step error:", err)
-				break
-			}
-			n += c
-		}
-		fillBuf(frameBuf, m.Display.Image())
-		return frameBuf
+	// The UI may change the options from its own goroutine, the processor is rebuilt in the frame loop.
+	var (
+		postMu      sync.Mutex
+		postChanged *devices.PostOptions
+	)
+	post := devices.NewPostProcessor(devices.DefaultPostOptions(), m.Display)
+	ui.ConfigureDisplay(func(opts devices.PostOptions) {
+		postMu.Lock()
+		postChanged = &opts
+		postMu.Unlock()
 	})
 
+	ui.ConnectAudio(m.Speaker)
+	ui.ControlRun(m)
 	ui.ConnectKeyboard(m.Keyboard)
 
-	var done chan struct{}
-	<-done
+	// The machine runs in its own goroutine, the frames are presented in this one.
+	frames, stop := m.Frames()
+	opts := runOptions
+	opts.OnFrame = ui.Poll
+	ctx, cancel := context.WithCancel(context.Background())
+	if done := ui.Done(); done != nil {
+		go func() {
+			<-done
+			cancel()
+		}()
+	}
+	go func() {
+		if err := m.Run(ctx, opts); err != nil && !errors.Is(err, context.Canceled) {
+			log.Println("step error:", err)
+		}
+		stop()
+	}()
+
+	var last uint64
+	for f := range frames {
+		dirty := f.Dirty
+		if f.Seq != last+1 {
+			// The changes of the missed frames are not known.
+			dirty = []image.Rectangle{f.Image.Bounds()}
+		}
+		last = f.Seq
+		postMu.Lock()
+		if postChanged != nil {
+			post = devices.NewPostProcessor(*postChanged, m.Display)
+			postChanged = nil
+		}
+		postMu.Unlock()
+		ui.PresentFrame(post.Process(f.Image, dirty))
+		f.Release()
+	}
+
+	// The simulation is stopped, the machine can be used in this goroutine.
+	ui.Close()
+	if err := finishMovie(m, movieRec, player); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// startMovie starts recording or replaying the movie selected by movieOptions.
+func startMovie(m *fahivets.Computer) (*fahivets.MovieRecorder, *fahivets.MoviePlayer, error) {
+	if movieOptions.Record != "" {
+		return m.RecordMovie(programRks, movieOptions.FrameRate), nil, nil
+	}
+	if movieOptions.Replay == "" {
+		return nil, nil, nil
+	}
+	f, err := os.Open(movieOptions.Replay)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	movie, err := fahivets.ReadMovie(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", movieOptions.Replay, err)
+	}
+	player, err := m.PlayMovie(movie, programRks)
+	return nil, player, err
+}
+
+// finishMovie writes the recorded movie, or reports whether the replay matched the movie.
+func finishMovie(m *fahivets.Computer, rec *fahivets.MovieRecorder, player *fahivets.MoviePlayer) error {
+	if player != nil {
+		switch err := player.Verify(); {
+		case m.CPU.Cycles < player.End():
+			log.Println("the replay is stopped before the movie end")
+		case err != nil:
+			log.Println(err)
+		default:
+			log.Println("the replay matches the movie")
+		}
+	}
+	if rec == nil {
+		return nil
+	}
+	movie := rec.Stop()
+	f, err := os.Create(movieOptions.Record)
+	if err != nil {
+		return err
+	}
+	if _, err := movie.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	log.Printf("recorded %d key events to %s", len(movie.Keys), movieOptions.Record)
+	return f.Close()
 }
 
 type UiWorld interface {
-	ConsumeDisplayFrames(frameF func() image.Image)
+	// PresentFrame shows the frame image, dirty are its regions changed since the previous frame.
+	PresentFrame(img *image.RGBA, dirty []image.Rectangle)
+	// Poll passes the user input to the machine. It is called after every frame of the emulated time,
+	// in the goroutine running the simulation.
+	Poll()
+	// ConfigureDisplay passes the post processing options selected by the user to configure.
+	// It may be called again when the options change.
+	ConfigureDisplay(configure func(opts devices.PostOptions))
+	// ControlRun lets the user pause the simulation and change its speed.
+	ControlRun(c RunController)
 	ConnectKeyboard(keyboard *devices.Keyboard)
+	ConnectAudio(speaker *devices.Speaker)
+	// Done is closed when the user quits, it is nil if the UI runs until the process is killed.
+	Done() <-chan struct{}
+	// Close restores the host state changed by the UI after the simulation stops.
+	Close()
+}
+
+// RunController is implemented by fahivets.Computer, its methods are safe to call from any goroutine.
+type RunController interface {
+	Pause()
+	Resume()
+	Paused() bool
+	SetSpeed(speed float64)
+}
+
+// parseSpeed parses the speed multiplier, "turbo" runs the simulation as fast as possible.
+func parseSpeed(s string) (float64, error) {
+	if s == "turbo" {
+		return fahivets.SpeedUnlimited, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("bad speed %q: want a positive multiplier or turbo", s)
+	}
+	return v, nil
 }
 
 func prepareSimulation(m *fahivets.Computer) {
@@ -90,7 +225,3 @@ func prepareSimulation(m *fahivets.Computer) {
 	copy(m.CPU.Memory[program.StartAddress:], program.Content)
 	m.CPU.Exec(arch.JMP(program.StartAddress))
 }
-
-func fillBuf(buf *image.RGBA, frame image.Image) {
-	draw.NearestNeighbor.Scale(buf, buf.Bounds(), frame, frame.Bounds(), draw.Src, nil)
-}
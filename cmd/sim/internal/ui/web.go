@@ -5,6 +5,8 @@ package main
 import (
 	"image"
 	"log"
+	"net/url"
+	"sync"
 	"syscall/js"
 	"unsafe"
 
@@ -12,19 +14,81 @@ import (
 )
 
 func makeUiWorld() UiWorld {
-	return &jsUiWorld{root: js.Global()}
+	w := &jsUiWorld{root: js.Global()}
+	w.requestFrames()
+	return w
 }
 
 type jsUiWorld struct {
-	root js.Value
+	root  js.Value
+	audio *jsAudioSink
+
+	// frame is the last presented frame, dirty are its regions changed since the canvas was updated.
+	frame *image.RGBA
+	dirty []image.Rectangle
+
+	// events are the browser events applied to the machine by Poll.
+	mu     sync.Mutex
+	events []func()
+}
+
+// maxDirty is the number of the pending dirty regions after which the whole frame is redrawn.
+const maxDirty = 64
+
+// maxEvents is the number of the pending events after which the pressed keys are dropped, like when
+// the simulation is paused. Other events are kept, so the keys are not left pressed.
+const maxEvents = 256
+
+// PresentFrame keeps the frame until the browser is ready to paint it. Go runs in one thread with JS, so the
+// frame is not changed while it's copied to the canvas.
+func (w *jsUiWorld) PresentFrame(img *image.RGBA, dirty []image.Rectangle) {
+	if w.frame != img || len(w.dirty)+len(dirty) > maxDirty {
+		w.dirty = append(w.dirty[:0], img.Bounds())
+	} else {
+		w.dirty = append(w.dirty, dirty...)
+	}
+	w.frame = img
 }
 
-func (w *jsUiWorld) ConsumeDisplayFrames(f func() image.Image) {
+// Done returns nil: the simulation runs until the page is closed.
+func (w *jsUiWorld) Done() <-chan struct{} { return nil }
+
+func (w *jsUiWorld) Close() {}
+
+// queue passes the event to Poll. Droppable events are ignored if too many events are pending.
+func (w *jsUiWorld) queue(droppable bool, e func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if droppable && len(w.events) >= maxEvents {
+		return
+	}
+	w.events = append(w.events, e)
+}
+
+// Poll applies the browser events to the machine and passes the speaker samples to the audio worklet.
+func (w *jsUiWorld) Poll() {
+	w.mu.Lock()
+	events := w.events
+	w.events = nil
+	w.mu.Unlock()
+	for _, e := range events {
+		e()
+	}
+	if w.audio != nil {
+		w.audio.flush()
+	}
+}
+
+// requestFrames updates the canvas on every animation frame of the browser.
+func (w *jsUiWorld) requestFrames() {
 	const callName = "requestAnimationFrame"
 
 	var jsHandler js.Func
 	jsHandler = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-		renderDisplayImage(imageToRGBA(f()))
+		if w.frame != nil {
+			renderDisplayImage(w.frame, w.dirty)
+			w.dirty = w.dirty[:0]
+		}
 
 		w.root.Call(callName, jsHandler)
 		return nil
@@ -33,131 +97,127 @@ func (w *jsUiWorld) ConsumeDisplayFrames(f func() image.Image) {
 	w.root.Call(callName, jsHandler)
 }
 
+// ControlRun exposes setSpeed to JS. It takes the speed multiplier, "turbo", or "pause".
+func (w *jsUiWorld) ControlRun(c RunController) {
+	w.root.Set("setSpeed", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		value := args[0].String()
+		if value == "pause" {
+			c.Pause()
+			return true
+		}
+		speed, err := parseSpeed(value)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		c.SetSpeed(speed)
+		c.Resume()
+		return true
+	}))
+	w.root.Call("initSpeed")
+}
+
+// ConfigureDisplay exposes setDisplayOptions to JS. It takes the options as a query string,
+// like "palette=green&scanlines=0.3", applied over the defaults.
+func (w *jsUiWorld) ConfigureDisplay(configure func(opts devices.PostOptions)) {
+	w.root.Set("setDisplayOptions", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		query, err := url.ParseQuery(args[0].String())
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		opts := devices.DefaultPostOptions()
+		for name, values := range query {
+			if err := opts.Set(name, values[len(values)-1]); err != nil {
+				log.Println(err)
+				return false
+			}
+		}
+		configure(opts)
+		return true
+	}))
+	w.root.Call("initDisplayOptions")
+}
+
+func (w *jsUiWorld) ConnectAudio(speaker *devices.Speaker) {
+	log.Println("Connecting audio...")
+	w.root.Call("initAudio", speaker.SampleRate())
+	w.audio = &jsAudioSink{speaker: speaker}
+}
+
+// jsAudioSink passes the samples generated by the speaker to the audio worklet after every frame.
+type jsAudioSink struct {
+	speaker *devices.Speaker
+	buf     []float32
+}
+
+func (s *jsAudioSink) flush() {
+	n := s.speaker.Buffered()
+	if n == 0 {
+		return
+	}
+	if cap(s.buf) < n {
+		s.buf = make([]float32, n)
+	}
+	s.buf = s.buf[:n]
+	s.speaker.Pull(s.buf)
+
+	ptr := uintptr(unsafe.Pointer(&s.buf[0]))
+	js.Global().Call("pushAudio", ptr, n)
+}
+
 func (w *jsUiWorld) ConnectKeyboard(keyboard *devices.Keyboard) {
 	log.Println("Connecting keyboard...")
 
+	km, _ := devices.BuiltinKeymap(devices.DefaultKeymap)
+	input := devices.NewKeymapInput(keyboard, km)
+
+	w.root.Set("setKeymap", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		name := args[0].String()
+		km, err := devices.BuiltinKeymap(name)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		log.Printf("keymap %s: %s", name, km.Name)
+		w.queue(false, func() { input.SetKeymap(km) })
+		return true
+	}))
+	var keymaps []interface{}
+	for _, name := range devices.Keymaps() {
+		km, _ := devices.BuiltinKeymap(name)
+		keymaps = append(keymaps, map[string]interface{}{"name": name, "title": km.Name})
+	}
+	w.root.Call("initKeymaps", keymaps)
+
 	docEl := w.root.Get("document").Get("documentElement")
 	const callName = "addEventListener"
 	docEl.Call(callName, "keydown", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-		handleKeyboardEvent(jsEventCode(args), true, keyboard)
+		code, key := args[0].Get("code").String(), args[0].Get("key").String()
+		w.queue(true, func() {
+			if !input.Press(code, key) {
+				log.Println("no keyboard mapping for", code, key)
+			}
+		})
 		return nil
 	}))
 	docEl.Call(callName, "keyup", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-		handleKeyboardEvent(jsEventCode(args), false, keyboard)
+		code := args[0].Get("code").String()
+		w.queue(false, func() { input.Release(code) })
 		return nil
 	}))
 }
 
-func jsEventCode(args []js.Value) string { return args[0].Get("code").String() }
-
-func handleKeyboardEvent(code string, down bool, keyboard *devices.Keyboard) {
-	if keyCode, present := jsKeyCodes[code]; present {
-		state := devices.KeyStateUp
-		if down {
-			state = devices.KeyStateDown
-		}
-		log.Printf("code %s, keycode %v, state %v", code, keyCode, state)
-		keyboard.Event(keyCode, state)
-	} else if down {
-		log.Println("no keyboard mapping for", code)
+// renderDisplayImage passes the changed regions of the image to the canvas.
+func renderDisplayImage(buf *image.RGBA, dirty []image.Rectangle) {
+	if len(dirty) == 0 {
+		return
+	}
+	rects := make([]interface{}, 0, len(dirty)*4)
+	for _, r := range dirty {
+		rects = append(rects, r.Min.X, r.Min.Y, r.Dx(), r.Dy())
 	}
-}
-
-func renderDisplayImage(buf *image.RGBA) {
 	ptr := uintptr(unsafe.Pointer(&buf.Pix[0]))
 	size := buf.Bounds().Size()
-	js.Global().Call("renderDisplay", ptr, len(buf.Pix), size.X, size.Y)
-}
-
-func imageToRGBA(img image.Image) *image.RGBA {
-	rgba, ok := img.(*image.RGBA)
-	if ok {
-		return rgba
-	}
-
-	rgba = image.NewRGBA(img.Bounds())
-	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
-		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
-			rgba.Set(x, y, img.At(x, y))
-		}
-	}
-	return rgba
-}
-
-var jsKeyCodes = map[string]devices.KeyCode{
-	"F1":  devices.MatrixKeyCode(5, 11),
-	"F2":  devices.MatrixKeyCode(5, 10),
-	"F3":  devices.MatrixKeyCode(5, 9),
-	"F4":  devices.MatrixKeyCode(5, 8),
-	"F5":  devices.MatrixKeyCode(5, 7),
-	"F6":  devices.MatrixKeyCode(5, 6),
-	"F7":  devices.MatrixKeyCode(5, 5),
-	"F8":  devices.MatrixKeyCode(5, 4),
-	"F9":  devices.MatrixKeyCode(5, 3),
-	"F10": devices.MatrixKeyCode(5, 2),
-	"F11": devices.MatrixKeyCode(5, 1),
-	"F12": devices.MatrixKeyCode(5, 0),
-
-	"IntlBackslash": devices.MatrixKeyCode(4, 11),
-	"Digit1":        devices.MatrixKeyCode(4, 10),
-	"Digit2":        devices.MatrixKeyCode(4, 9),
-	"Digit3":        devices.MatrixKeyCode(4, 8),
-	"Digit4":        devices.MatrixKeyCode(4, 7),
-	"Digit5":        devices.MatrixKeyCode(4, 6),
-	"Digit6":        devices.MatrixKeyCode(4, 5),
-	"Digit7":        devices.MatrixKeyCode(4, 4),
-	"Digit8":        devices.MatrixKeyCode(4, 3),
-	"Digit9":        devices.MatrixKeyCode(4, 2),
-	"Digit0":        devices.MatrixKeyCode(4, 1),
-	"Equal":         devices.MatrixKeyCode(4, 0),
-
-	"KeyQ":         devices.MatrixKeyCode(3, 11),
-	"KeyW":         devices.MatrixKeyCode(3, 10),
-	"KeyE":         devices.MatrixKeyCode(3, 9),
-	"KeyR":         devices.MatrixKeyCode(3, 8),
-	"KeyT":         devices.MatrixKeyCode(3, 7),
-	"KeyY":         devices.MatrixKeyCode(3, 6),
-	"KeyU":         devices.MatrixKeyCode(3, 5),
-	"KeyI":         devices.MatrixKeyCode(3, 4),
-	"KeyO":         devices.MatrixKeyCode(3, 3),
-	"KeyP":         devices.MatrixKeyCode(3, 2),
-	"BracketLeft":  devices.MatrixKeyCode(3, 1),
-	"BracketRight": devices.MatrixKeyCode(3, 0),
-
-	"KeyA":      devices.MatrixKeyCode(2, 11),
-	"KeyS":      devices.MatrixKeyCode(2, 10),
-	"KeyD":      devices.MatrixKeyCode(2, 9),
-	"KeyF":      devices.MatrixKeyCode(2, 8),
-	"KeyG":      devices.MatrixKeyCode(2, 7),
-	"KeyH":      devices.MatrixKeyCode(2, 6),
-	"KeyJ":      devices.MatrixKeyCode(2, 5),
-	"KeyK":      devices.MatrixKeyCode(2, 4),
-	"KeyL":      devices.MatrixKeyCode(2, 3),
-	"Semicolon": devices.MatrixKeyCode(2, 2),
-	"Quote":     devices.MatrixKeyCode(2, 1),
-	"Backslash": devices.MatrixKeyCode(2, 0),
-
-	"KeyZ":      devices.MatrixKeyCode(1, 11),
-	"KeyX":      devices.MatrixKeyCode(1, 10),
-	"KeyC":      devices.MatrixKeyCode(1, 9),
-	"KeyV":      devices.MatrixKeyCode(1, 8),
-	"KeyB":      devices.MatrixKeyCode(1, 7),
-	"KeyN":      devices.MatrixKeyCode(1, 6),
-	"KeyM":      devices.MatrixKeyCode(1, 5),
-	"Comma":     devices.MatrixKeyCode(1, 4),
-	"Period":    devices.MatrixKeyCode(1, 3),
-	"Slash":     devices.MatrixKeyCode(1, 2),
-	"Backquote": devices.MatrixKeyCode(1, 1),
-	"Backspace": devices.MatrixKeyCode(1, 0),
-
-	"ShiftLeft":  devices.MatrixKeyCode(0, 11),
-	"MetaLeft":   devices.MatrixKeyCode(0, 10),
-	"ArrowUp":    devices.MatrixKeyCode(0, 9),
-	"ArrowDown":  devices.MatrixKeyCode(0, 8),
-	"Space":      devices.MatrixKeyCode(0, 5),
-	"ArrowLeft":  devices.MatrixKeyCode(0, 4),
-	"Enter":      devices.MatrixKeyCode(0, 3),
-	"ArrowRight": devices.MatrixKeyCode(0, 2),
-	"MetaRight":  devices.MatrixKeyCode(0, 1),
-	"AltRight":   devices.MatrixKeyCode(0, 0),
+	js.Global().Call("renderDisplay", ptr, len(buf.Pix), size.X, size.Y, rects)
 }
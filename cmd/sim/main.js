@@ -1,10 +1,145 @@
 const go = new Go();
 
 const fetchMain = WebAssembly.instantiateStreaming(
-  fetch("main.wasm?v=6"),
+  fetch("main.wasm?v=12"),
   go.importObject
 );
 
+function setupAudio(container) {
+  const overlay = container.getElementsByClassName("audio-overlay")[0];
+  const muteButton = container.getElementsByClassName("mute")[0];
+  const volumeInput = container.getElementsByClassName("volume")[0];
+
+  const audio = {
+    ctx: null,
+    node: null,
+    gain: null,
+    muted: localStorage.getItem("muted") === "true",
+    volume: Number(localStorage.getItem("volume") ?? 70),
+  };
+
+  const applyVolume = () => {
+    muteButton.textContent = audio.muted ? "🔇" : "🔊";
+    volumeInput.value = audio.volume;
+    if (audio.gain) {
+      audio.gain.gain.value = audio.muted ? 0 : audio.volume / 100;
+    }
+    localStorage.setItem("muted", audio.muted);
+    localStorage.setItem("volume", audio.volume);
+  };
+  applyVolume();
+
+  muteButton.addEventListener("click", () => {
+    audio.muted = !audio.muted;
+    applyVolume();
+    // Keep the keyboard input for the simulator.
+    muteButton.blur();
+  });
+  volumeInput.addEventListener("input", () => {
+    audio.volume = Number(volumeInput.value);
+    audio.muted = false;
+    applyVolume();
+  });
+  volumeInput.addEventListener("change", () => volumeInput.blur());
+
+  // Browsers do not allow playing the sound until the user interacts with the page.
+  const updateOverlay = () => {
+    overlay.classList.toggle("hidden", audio.ctx.state === "running");
+  };
+  overlay.addEventListener("click", () => {
+    audio.ctx.resume().then(updateOverlay);
+  });
+
+  window.initAudio = (sampleRate) => {
+    audio.ctx = new AudioContext({sampleRate, latencyHint: "interactive"});
+    audio.ctx.onstatechange = updateOverlay;
+    updateOverlay();
+
+    audio.ctx.audioWorklet.addModule("audio-worklet.js?v=1").then(() => {
+      audio.node = new AudioWorkletNode(audio.ctx, "speaker", {outputChannelCount: [1]});
+      audio.gain = audio.ctx.createGain();
+      audio.node.connect(audio.gain).connect(audio.ctx.destination);
+      applyVolume();
+    }).catch(e => console.error("cannot initialize audio", e));
+  };
+
+  return audio;
+}
+
+function setupKeymaps(container) {
+  const select = container.getElementsByClassName("keymap")[0];
+
+  window.initKeymaps = (keymaps) => {
+    for (const km of keymaps) {
+      const option = document.createElement("option");
+      option.value = km.name;
+      option.textContent = km.name;
+      option.title = km.title;
+      select.appendChild(option);
+    }
+    const stored = localStorage.getItem("keymap");
+    if (stored && window.setKeymap(stored)) {
+      select.value = stored;
+    }
+  };
+
+  select.addEventListener("change", () => {
+    if (window.setKeymap(select.value)) {
+      localStorage.setItem("keymap", select.value);
+    }
+    // Keep the keyboard input for the simulator.
+    select.blur();
+  });
+}
+
+function setupDisplayOptions(container) {
+  const palette = container.getElementsByClassName("palette")[0];
+  const effects = container.getElementsByClassName("effects")[0];
+
+  const apply = () => {
+    const options = "palette=" + palette.value + (effects.value ? "&" + effects.value : "");
+    if (window.setDisplayOptions(options)) {
+      localStorage.setItem("palette", palette.value);
+      localStorage.setItem("effects", effects.value);
+    }
+  };
+
+  window.initDisplayOptions = () => {
+    palette.value = localStorage.getItem("palette") ?? palette.value;
+    effects.value = localStorage.getItem("effects") ?? effects.value;
+    if (palette.selectedIndex < 0) {
+      palette.selectedIndex = 0;
+    }
+    if (effects.selectedIndex < 0) {
+      effects.selectedIndex = 0;
+    }
+    apply();
+  };
+
+  for (const select of [palette, effects]) {
+    select.addEventListener("change", () => {
+      apply();
+      // Keep the keyboard input for the simulator.
+      select.blur();
+    });
+  }
+}
+
+function setupSpeed(container) {
+  const select = container.getElementsByClassName("speed")[0];
+
+  // The speed is not stored, the simulator always starts in real time.
+  window.initSpeed = () => {
+    select.value = "1";
+  };
+
+  select.addEventListener("change", () => {
+    window.setSpeed(select.value);
+    // Keep the keyboard input for the simulator.
+    select.blur();
+  });
+}
+
 addEventListener("DOMContentLoaded", () => {
   const container = document.getElementById("mainApp");
 
@@ -13,17 +148,40 @@ addEventListener("DOMContentLoaded", () => {
   canvas.height = window.innerHeight;
 
   const graphCtx = canvas.getContext("2d");
+  const audio = setupAudio(container);
+  setupKeymaps(container);
+  setupDisplayOptions(container);
+  setupSpeed(container);
 
   console.debug("document loaded, start main code")
   fetchMain.then(wasm => {
     let sharedMemory;
+    let width = 0, height = 0;
 
-    window.renderDisplay = (ptr, len, w, h) => {
+    // Only the regions listed in rects (x, y, width, height, ...) are updated.
+    window.renderDisplay = (ptr, len, w, h, rects) => {
       if (!sharedMemory || sharedMemory.byteLength === 0) {
         sharedMemory = new Uint8ClampedArray(wasm.instance.exports.mem.buffer);
       }
-      const data = sharedMemory.subarray(ptr, ptr + len);
-      graphCtx.putImageData(new ImageData(data, w, h), 0, 0);
+      if (w !== width || h !== height) {
+        // The display options are changed.
+        graphCtx.clearRect(0, 0, canvas.width, canvas.height);
+        width = w;
+        height = h;
+      }
+      const image = new ImageData(sharedMemory.subarray(ptr, ptr + len), w, h);
+      for (let i = 0; i < rects.length; i += 4) {
+        graphCtx.putImageData(image, 0, 0, rects[i], rects[i + 1], rects[i + 2], rects[i + 3]);
+      }
+    };
+
+    window.pushAudio = (ptr, len) => {
+      if (!audio.node || audio.ctx.state !== "running") {
+        return;
+      }
+      // Copy the samples as the Go buffer is reused for the next frame.
+      const samples = new Float32Array(wasm.instance.exports.mem.buffer, ptr, len).slice();
+      audio.node.port.postMessage(samples, [samples.buffer]);
     };
 
     go.run(wasm.instance)
@@ -7,45 +7,83 @@ import (
 	"rmazur.io/fahivets/devices"
 )
 
+const (
+	// ClockFrequency is the number of CPU cycles per second.
+	ClockFrequency = 2_000_000
+	// AudioSampleRate is the sample rate of the speaker output.
+	AudioSampleRate = 44100
+)
+
 type Computer struct {
+	Profile  Profile
 	CPU      arch.CPU
 	Keyboard *devices.Keyboard
 	Display  *devices.Display
+	Speaker  *devices.Speaker
+
+	// Scheduler fires device events synchronized with the CPU cycles.
+	Scheduler *devices.Scheduler
 
-	ioCtl         *arch.IoController
-	portBComposer *devices.PortComposer
+	ioCtl  *arch.IoController
+	wiring *devices.Wiring
 
-	cyclesSinceSleep int
+	// Run paces the cycles run since lastSleep against the wall time passed since it.
+	cyclesSinceSleep uint64
 	lastSleep        time.Time
+	clock            Clock
+	control          runControl
+	frames           frameStore
 }
 
-func NewComputer() *Computer {
-	var c Computer
+// NewComputer creates the stock machine.
+func NewComputer() *Computer { return NewComputerProfile(ProfileStandard) }
+
+// NewComputerProfile creates the machine variant described by the profile.
+func NewComputerProfile(p Profile) *Computer {
+	c := Computer{Profile: p}
+	c.control.speed = 1
+	c.Scheduler = devices.NewScheduler(&c.CPU.Cycles)
 	c.ioCtl = arch.InitIoController(&c.CPU)
 
-	c.portBComposer = devices.NewPortComposer(c.ioCtl.SendB)
+	c.wiring = devices.NewWiring(c.ioCtl, &c.CPU.Cycles)
+
+	// Devices declare the pins they are connected to.
+	c.Keyboard = devices.NewKeyboard(c.wiring, c.Scheduler)
+	c.Speaker = devices.NewSpeaker(ClockFrequency, AudioSampleRate)
+	c.Speaker.Connect(c.wiring)
 
-	c.Keyboard = devices.NewKeyboard(&devices.ComposedIoController{
-		PortA: c.ioCtl.SendA,
-		// Keyboard is not connected to lower 2 bits.
-		PortB:    c.portBComposer.MaskedSend(0xFC),
-		PortCLow: c.ioCtl.SendCLow,
-	})
+	c.Display = devices.NewColorDisplay(&c.CPU, p.ColorMode, p.Palette)
+	c.Display.Connect(c.wiring)
 
-	c.Display = devices.NewDisplay(&c.CPU)
+	// The tape pins are not declared: the tape is not emulated at the signal level, the programs are loaded
+	// into the memory directly, and the tape.go converters only read and write the recordings. A tape device
+	// will declare its pins like the speaker does.
 	return &c
 }
 
-func (c *Computer) Shutdown() {
-	c.Keyboard.ShutDown()
-	c.portBComposer.ShutDown()
-}
+// Wiring returns the nets of the IO controller pins the devices are connected to.
+func (c *Computer) Wiring() *devices.Wiring { return c.wiring }
 
 func (c *Computer) Step() (cmd arch.Instruction, cycles int, err error) {
 	cmd, cycles, err = c.CPU.Step()
 	if err != nil {
 		return
 	}
-	c.ioCtl.Sync()
+	c.Speaker.Advance(c.CPU.Cycles)
+	c.Scheduler.Run()
 	return
 }
+
+// RunUntil executes the instructions until the cycle counter reaches the specified value.
+// Scheduled events fire as soon as their cycle is reached.
+func (c *Computer) RunUntil(cycle uint64) error {
+	for c.CPU.Cycles < cycle {
+		if _, _, err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunFor executes the instructions for the specified number of cycles.
+func (c *Computer) RunFor(cycles uint64) error { return c.RunUntil(c.CPU.Cycles + cycles) }
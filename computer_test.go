@@ -0,0 +1,63 @@
+package fahivets_test
+
+import (
+	"testing"
+
+	"rmazur.io/fahivets"
+	"rmazur.io/fahivets/arch"
+	"rmazur.io/fahivets/devices"
+)
+
+func TestComputerScheduler(t *testing.T) {
+	m := fahivets.NewComputer()
+	// Infinite loop at the address 0.
+	arch.EncodeInstructions([]arch.Instruction{arch.NOP(), arch.JMP(0)}, m.CPU.Memory[:])
+
+	var firedAt []uint64
+	for _, at := range []uint64{100, 50, 1000} {
+		m.Scheduler.At(at, func() { firedAt = append(firedAt, m.CPU.Cycles) })
+	}
+
+	if err := m.RunUntil(500); err != nil {
+		t.Fatal(err)
+	}
+	if len(firedAt) != 2 {
+		t.Fatalf("got %d events fired; want 2", len(firedAt))
+	}
+	for i, want := range []uint64{50, 100} {
+		// An event fires after the instruction that reaches its cycle.
+		if got := firedAt[i]; got < want || got > want+3 {
+			t.Errorf("event %d fired at %d; want %d", i, got, want)
+		}
+	}
+
+	if err := m.RunFor(1000); err != nil {
+		t.Fatal(err)
+	}
+	if len(firedAt) != 3 {
+		t.Errorf("got %d events fired; want 3", len(firedAt))
+	}
+}
+
+func TestComputerProfiles(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		mode devices.ColorMode
+	}{
+		{"standard", devices.ColorNone},
+		{"color", devices.ColorPortC},
+		{"mx", devices.ColorRegister},
+	} {
+		p, err := fahivets.LookupProfile(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := fahivets.NewComputerProfile(p)
+		if got := m.Display.ColorMode(); got != tc.mode {
+			t.Errorf("%s: got color mode %d; want %d", tc.name, got, tc.mode)
+		}
+	}
+	if _, err := fahivets.LookupProfile("orion"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
@@ -1,32 +1,297 @@
 package devices
 
 import (
+	"bytes"
+	"hash/fnv"
 	"image"
 	"image/color"
 
 	"rmazur.io/fahivets/arch"
 )
 
+// DisplayHeight is the number of display lines. Every byte of the display memory is 8 pixels of a line,
+// the bytes go column by column: 256 bytes of the first column, then the next one.
+const DisplayHeight = 256
+
+// ColorMode defines how the colour of the display bytes is selected.
+type ColorMode byte
+
+const (
+	// ColorNone is the stock monochrome display.
+	ColorNone ColorMode = iota
+	// ColorPortC latches the ink colour from the port C pins 4, 6 and 7 of the IO controller on every
+	// display memory write. The index is PC7<<2 | PC6<<1 | PC4 (8 colours) on the black paper.
+	ColorPortC
+	// ColorRegister latches the colour written to the colour register (ColorRegisterAddr) on every
+	// display memory write, like Specialist-MX does. The upper 4 bits select the ink, the lower 4 bits
+	// select the paper (16 colours).
+	ColorRegister
+)
+
+// ColorRegisterAddr is the address of the colour register used with ColorRegister mode.
+// The register takes 4 addresses over the IO controller registers.
+const ColorRegisterAddr = 0xFFF8
+
+var (
+	// Palette8 is the palette for the ColorPortC mode.
+	Palette8 = color.Palette{
+		color.RGBA{0x00, 0x00, 0x00, 0xFF}, color.RGBA{0x00, 0x00, 0xFF, 0xFF},
+		color.RGBA{0x00, 0xFF, 0x00, 0xFF}, color.RGBA{0x00, 0xFF, 0xFF, 0xFF},
+		color.RGBA{0xFF, 0x00, 0x00, 0xFF}, color.RGBA{0xFF, 0x00, 0xFF, 0xFF},
+		color.RGBA{0xFF, 0xFF, 0x00, 0xFF}, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+	}
+	// Palette16 is the palette for the ColorRegister mode: 8 dim colours followed by 8 bright ones.
+	Palette16 = color.Palette{
+		color.RGBA{0x00, 0x00, 0x00, 0xFF}, color.RGBA{0x00, 0x00, 0xAA, 0xFF},
+		color.RGBA{0x00, 0xAA, 0x00, 0xFF}, color.RGBA{0x00, 0xAA, 0xAA, 0xFF},
+		color.RGBA{0xAA, 0x00, 0x00, 0xFF}, color.RGBA{0xAA, 0x00, 0xAA, 0xFF},
+		color.RGBA{0xAA, 0x55, 0x00, 0xFF}, color.RGBA{0xAA, 0xAA, 0xAA, 0xFF},
+		color.RGBA{0x55, 0x55, 0x55, 0xFF}, color.RGBA{0x55, 0x55, 0xFF, 0xFF},
+		color.RGBA{0x55, 0xFF, 0x55, 0xFF}, color.RGBA{0x55, 0xFF, 0xFF, 0xFF},
+		color.RGBA{0xFF, 0x55, 0x55, 0xFF}, color.RGBA{0xFF, 0x55, 0xFF, 0xFF},
+		color.RGBA{0xFF, 0xFF, 0x55, 0xFF}, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+	}
+)
+
 type Display struct {
 	mem []byte
+	// font is the character generator used by Text.
+	font []byte
+
+	mode ColorMode
+	// Palette is used to render the colour modes.
+	Palette color.Palette
+	// colors is the colour attribute plane parallel to mem: the ink index in the upper 4 bits,
+	// the paper index in the lower ones.
+	colors []byte
+	latch  byte
 }
 
+// NewDisplay creates the monochrome display.
 func NewDisplay(cpu *arch.CPU) *Display {
 	displayStart, displayEnd := arch.MemoryMappingRange(arch.MemDisplay12K)
 	return &Display{
-		mem: cpu.Memory[displayStart : displayEnd+1],
+		mem:  cpu.Memory[displayStart : displayEnd+1],
+		font: cpu.Memory[FontAddr : FontAddr+0x80*8],
+	}
+}
+
+// NewColorDisplay creates the display with the colour plane. The display memory writes are captured on the bus,
+// so it must be created after the IO controller is mapped. The ColorPortC mode also requires Connect.
+// The display memory written before gets the white ink on the black paper.
+func NewColorDisplay(cpu *arch.CPU, mode ColorMode, palette color.Palette) *Display {
+	d := NewDisplay(cpu)
+	if mode == ColorNone {
+		return d
+	}
+	d.mode, d.Palette = mode, palette
+	d.latch = byte(min(len(palette), 16)-1) << 4
+	d.colors = bytes.Repeat([]byte{d.latch}, len(d.mem))
+
+	displayStart, displayEnd := arch.MemoryMappingRange(arch.MemDisplay12K)
+	cpu.MapDevice(uint16(displayStart), uint16(displayEnd), d)
+	if mode == ColorRegister {
+		cpu.MapDevice(ColorRegisterAddr, ColorRegisterAddr, &colorRegister{d: d, cpu: cpu, next: cpu.Device(ColorRegisterAddr)})
+	}
+	return d
+}
+
+// Connect connects the ColorPortC display to the port C pins. It does nothing in other modes.
+func (c *Display) Connect(w *Wiring) {
+	if c.mode != ColorPortC {
+		return
+	}
+	w.Sense(Pins{Port: arch.PortC, Mask: 0xD0}, func(_ uint64, v byte) {
+		c.latch = (v>>6&3<<1 | v>>4&1) << 4
+	})
+}
+
+// ColorMode returns the display colour mode.
+func (c *Display) ColorMode() ColorMode { return c.mode }
+
+func (c *Display) offset(addr uint16) int {
+	start, _ := arch.MemoryMappingRange(arch.MemDisplay12K)
+	return int(addr) - start
+}
+
+// ReadMemory implements arch.MemoryDevice for the colour modes.
+func (c *Display) ReadMemory(addr uint16) byte { return c.mem[c.offset(addr)] }
+
+// WriteMemory implements arch.MemoryDevice for the colour modes: it stores the latched colour with the byte.
+func (c *Display) WriteMemory(addr uint16, v byte) {
+	i := c.offset(addr)
+	c.mem[i], c.colors[i] = v, c.latch
+}
+
+// colorRegister captures the writes to the colour register and passes other accesses to the device it overlaps.
+type colorRegister struct {
+	d    *Display
+	cpu  *arch.CPU
+	next arch.MemoryDevice
+}
+
+func (r *colorRegister) ReadMemory(addr uint16) byte {
+	if r.next != nil {
+		return r.next.ReadMemory(addr)
+	}
+	return r.cpu.Memory[addr]
+}
+
+func (r *colorRegister) WriteMemory(addr uint16, v byte) {
+	switch {
+	case addr&^3 == ColorRegisterAddr:
+		r.d.latch = v
+	case r.next != nil:
+		r.next.WriteMemory(addr, v)
+	default:
+		r.cpu.Memory[addr] = v
 	}
 }
 
+// Hash returns the FNV-1a hash of the display memory and the colour plane. Equal frames have equal hashes.
+func (c *Display) Hash() uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(c.mem)
+	_, _ = h.Write(c.colors)
+	return h.Sum64()
+}
+
+// Bounds returns the display size in pixels.
+func (c *Display) Bounds() image.Rectangle {
+	return image.Rect(0, 0, len(c.mem)/DisplayHeight*8, DisplayHeight)
+}
+
+// Image returns a new image with the current display content.
+// It's a grayscale image for the monochrome display, and an RGBA image for the colour modes.
 func (c *Display) Image() image.Image {
-	const height = 256
-	width := len(c.mem) / height * 8
-	img := image.NewGray(image.Rect(0, 0, width, height))
+	if c.mode != ColorNone {
+		// A renderer without the shadow planes draws every byte once.
+		r := DisplayRenderer{display: c, scale: 1, img: image.NewRGBA(c.Bounds()), palette: c.rgbaPalette()}
+		for i, b := range c.mem {
+			r.drawByte(i/DisplayHeight, i%DisplayHeight, b, c.colors[i])
+		}
+		return r.img
+	}
+	img := image.NewGray(c.Bounds())
 	for i, b := range c.mem {
-		x, y := i/height, i%height
+		x, y := i/DisplayHeight, i%DisplayHeight
+		pix := img.Pix[y*img.Stride+x*8:]
 		for s := range 8 {
-			img.Set(x*8+7-s, y, color.Gray{Y: (b >> s) & 1 * 255})
+			pix[7-s] = (b >> s) & 1 * 255
 		}
 	}
 	return img
 }
+
+// DisplayRenderer draws the display into a persistent RGBA image, updating only the parts that changed since the
+// previous frame.
+type DisplayRenderer struct {
+	display *Display
+	scale   int
+	img     *image.RGBA
+	// shadow and shadowColors are the display memory drawn in the image.
+	shadow, shadowColors []byte
+	drawn                bool
+
+	palette [16]color.RGBA
+}
+
+// NewRenderer creates a renderer that scales the display by the integer factor.
+// The palette of the colour display is captured at this moment.
+func (c *Display) NewRenderer(scale int) *DisplayRenderer {
+	b := c.Bounds()
+	r := &DisplayRenderer{
+		display: c,
+		scale:   scale,
+		img:     image.NewRGBA(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale)),
+		shadow:  make([]byte, len(c.mem)),
+		palette: c.rgbaPalette(),
+	}
+	if c.mode != ColorNone {
+		r.shadowColors = make([]byte, len(c.colors))
+	}
+	return r
+}
+
+// rgbaPalette returns the colours of the ink and paper indexes.
+func (c *Display) rgbaPalette() (p [16]color.RGBA) {
+	if c.mode == ColorNone {
+		// White ink on the black paper.
+		p[0] = color.RGBA{A: 0xFF}
+		p[1] = color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+		return
+	}
+	for i := range p {
+		p[i] = color.RGBAModel.Convert(c.Palette[i%len(c.Palette)]).(color.RGBA)
+	}
+	return
+}
+
+// Image returns the image the renderer draws into. It is reused by every Render call.
+func (r *DisplayRenderer) Image() *image.RGBA { return r.img }
+
+// Render draws the display memory changed since the previous call. It returns the image and the rectangles of
+// the image that were redrawn: one rectangle per run of the changed columns (8 pixels wide before scaling),
+// covering the changed lines. The first call redraws the whole image.
+func (r *DisplayRenderer) Render() (*image.RGBA, []image.Rectangle) {
+	var dirty []image.Rectangle
+	mem, colors := r.display.mem, r.display.colors
+	for col := 0; col*DisplayHeight < len(mem); col++ {
+		start, end := col*DisplayHeight, (col+1)*DisplayHeight
+		cur, prev := mem[start:end], r.shadow[start:end]
+		var curColors, prevColors []byte
+		if colors != nil {
+			curColors, prevColors = colors[start:end], r.shadowColors[start:end]
+		}
+		if r.drawn && bytes.Equal(cur, prev) && bytes.Equal(curColors, prevColors) {
+			continue
+		}
+		top, bottom := DisplayHeight, 0
+		for y, b := range cur {
+			attr := byte(0x10) // Monochrome: ink 1, paper 0.
+			if colors != nil {
+				attr = curColors[y]
+			}
+			if r.drawn && b == prev[y] && (colors == nil || attr == prevColors[y]) {
+				continue
+			}
+			r.drawByte(col, y, b, attr)
+			top, bottom = min(top, y), y+1
+		}
+		copy(prev, cur)
+		copy(prevColors, curColors)
+
+		rect := image.Rect(col*8, top, col*8+8, bottom)
+		if n := len(dirty); n > 0 && dirty[n-1].Max.X == rect.Min.X*r.scale {
+			// Join with the previous column.
+			dirty[n-1] = dirty[n-1].Union(r.scaled(rect))
+		} else {
+			dirty = append(dirty, r.scaled(rect))
+		}
+	}
+	r.drawn = true
+	return r.img, dirty
+}
+
+func (r *DisplayRenderer) scaled(rect image.Rectangle) image.Rectangle {
+	return image.Rectangle{Min: rect.Min.Mul(r.scale), Max: rect.Max.Mul(r.scale)}
+}
+
+func (r *DisplayRenderer) drawByte(col, y int, b, attr byte) {
+	s := r.scale
+	ink, paper := r.palette[attr>>4], r.palette[attr&0x0F]
+	for dy := range s {
+		offset := r.img.PixOffset(col*8*s, y*s+dy)
+		pix := r.img.Pix[offset : offset+8*s*4]
+		for bit := range 8 {
+			c := paper
+			if b&(0x80>>bit) != 0 {
+				c = ink
+			}
+			for dx := range s {
+				p := pix[(bit*s+dx)*4:]
+				p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package devices
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+
+	"rmazur.io/fahivets/arch"
+)
+
+func TestDisplayRenderer(t *testing.T) {
+	var cpu arch.CPU
+	d := NewDisplay(&cpu)
+	start, _ := arch.MemoryMappingRange(arch.MemDisplay12K)
+
+	r := d.NewRenderer(2)
+	img, dirty := r.Render()
+	if want := image.Rect(0, 0, 768, 512); img.Bounds() != want {
+		t.Errorf("got bounds %v; want %v", img.Bounds(), want)
+	}
+	if want := []image.Rectangle{img.Bounds()}; !slices.Equal(dirty, want) {
+		t.Errorf("got first frame dirty %v; want %v", dirty, want)
+	}
+	if _, dirty := r.Render(); len(dirty) != 0 {
+		t.Errorf("got dirty %v without changes", dirty)
+	}
+
+	cpu.Memory[start+10] = 0x81                  // Column 0, line 10.
+	cpu.Memory[start+DisplayHeight+20] = 0x01    // Column 1, line 20.
+	cpu.Memory[start+3*DisplayHeight+255] = 0x80 // Column 3, line 255.
+	img, dirty = r.Render()
+	want := []image.Rectangle{image.Rect(0, 20, 32, 42), image.Rect(48, 510, 64, 512)}
+	if !slices.Equal(dirty, want) {
+		t.Errorf("got dirty %v; want %v", dirty, want)
+	}
+
+	white, black := color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}, color.RGBA{A: 0xFF}
+	for _, tc := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 20, white}, {1, 21, white}, {2, 20, black}, {14, 21, white}, {15, 20, white},
+		{30, 40, white}, {31, 41, white}, {29, 40, black},
+		{48, 510, white}, {49, 511, white}, {50, 510, black},
+	} {
+		if got := img.RGBAAt(tc.x, tc.y); got != tc.want {
+			t.Errorf("got %v at %d,%d; want %v", got, tc.x, tc.y, tc.want)
+		}
+	}
+
+	// The renderer output matches the plain image.
+	gray := d.Image()
+	for y := range 256 {
+		for x := range 384 {
+			if g, c := gray.At(x, y).(color.Gray), img.RGBAAt(x*2, y*2); g.Y != c.R {
+				t.Fatalf("got %v at %d,%d; want %v", c, x, y, g)
+			}
+		}
+	}
+}
+
+func TestColorDisplay(t *testing.T) {
+	start, _ := arch.MemoryMappingRange(arch.MemDisplay12K)
+	white := Palette16[15]
+
+	t.Run("register", func(t *testing.T) {
+		var cpu arch.CPU
+		ioc := arch.InitIoController(&cpu)
+		d := NewColorDisplay(&cpu, ColorRegister, Palette16)
+		d.Connect(NewWiring(ioc, &cpu.Cycles))
+
+		cpu.Write(uint16(start), 0xF0) // Before any colour is set.
+		cpu.Write(ColorRegisterAddr, 0x4E)
+		cpu.Write(uint16(start+1), 0xF0)
+		// The IO controller is still available in the same page.
+		cpu.Write(arch.MemoryIoCtrl+3, 0x80)
+		if v := cpu.Read(arch.MemoryIoCtrl + 3); v != 0x80 {
+			t.Errorf("got IO controller mode 0x%02x; want 0x80", v)
+		}
+		if v := cpu.Read(uint16(start + 1)); v != 0xF0 {
+			t.Errorf("got 0x%02x from the display memory; want 0xf0", v)
+		}
+
+		img := d.Image()
+		for _, tc := range []struct {
+			x, y int
+			want color.Color
+		}{
+			{0, 0, white}, {4, 0, Palette16[0]},
+			{0, 1, Palette16[4]}, {7, 1, Palette16[14]},
+		} {
+			if got := img.At(tc.x, tc.y); got != tc.want {
+				t.Errorf("got %v at %d,%d; want %v", got, tc.x, tc.y, tc.want)
+			}
+		}
+	})
+
+	t.Run("port C", func(t *testing.T) {
+		var cpu arch.CPU
+		ioc := arch.InitIoController(&cpu)
+		d := NewColorDisplay(&cpu, ColorPortC, Palette8)
+		d.Connect(NewWiring(ioc, &cpu.Cycles))
+
+		r := d.NewRenderer(1)
+		r.Render()
+		cpu.Write(arch.MemoryIoCtrl+3, 0x80) // All ports are outputs.
+		cpu.Write(arch.MemoryIoCtrl+2, 0x50) // PC6 and PC4: green and blue.
+		cpu.Write(uint16(start+DisplayHeight), 0x80)
+		img, dirty := r.Render()
+		if want := []image.Rectangle{image.Rect(8, 0, 16, 1)}; !slices.Equal(dirty, want) {
+			t.Errorf("got dirty %v; want %v", dirty, want)
+		}
+		if got := img.At(8, 0); got != Palette8[3] {
+			t.Errorf("got %v; want %v", got, Palette8[3])
+		}
+		if got := img.At(9, 0); got != Palette8[0] {
+			t.Errorf("got %v; want %v", got, Palette8[0])
+		}
+
+		// Changing the colour of the same byte makes it dirty.
+		cpu.Write(arch.MemoryIoCtrl+2, 0x80)
+		cpu.Write(uint16(start+DisplayHeight), 0x80)
+		img, dirty = r.Render()
+		if len(dirty) != 1 || img.At(8, 0) != Palette8[4] {
+			t.Errorf("got dirty %v, color %v; want red", dirty, img.At(8, 0))
+		}
+
+		// The snapshot image matches the rendered one.
+		if snap := d.Image().(*image.RGBA); !bytes.Equal(snap.Pix, img.Pix) {
+			t.Error("the display image differs from the rendered one")
+		}
+	})
+}
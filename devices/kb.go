@@ -1,58 +1,118 @@
 package devices
 
+import "rmazur.io/fahivets/arch"
+
 // Keyboard implements simulation of Фахівець-85 keyboard.
 // It's 12x6 matrix connected to the IO controller.
-// 6 rows are mapped to the port B, pins 2-7 (pin 2 - row 6, pin 7 - row 1).
+// 6 rows are mapped to the port B, pins 2-7 (pin 2 - the bottom row 0, pin 7 - the top row 5),
+// the order matches the key codes table of the bootloader.
 // First 8 columns of the matrix are mapped to the pins of port A.
 // Last 4 columns are mapped to lower part of the port C.
-// So the implementation of the keyboard sends values to ports A, B, and lower C, on the keystrokes.
+// A pressed key connects its row and column, so the CPU scans the matrix driving the lines of one side
+// and reading the other one. The keyboard senses the levels driven by the CPU and pulls down the lines
+// connected to the low ones through the pressed keys. It works in both scan directions, and multi-key
+// presses produce the same ghosting as the real matrix does.
+// The НР (Shift) key is not a part of the matrix: it pulls down pin 1 of port B when pressed.
+// Events are applied synchronously, so the keyboard must be used from the routine that runs the CPU.
 type Keyboard struct {
-	ctl IoController
+	// Typing configures the timing of the Type method.
+	Typing Typing
+
+	pins   *Driver
+	sched  *Scheduler
+	matrix kbMatrix
+	shift  KeyState
 
-	events chan keyEvent
+	mode     kbMode // The last register switched with the keys.
+	typeMode kbMode // The register after the typed text.
+	typeEnd  uint64
+
+	observers []*keyObserver
 }
 
-func NewKeyboard(ctl IoController) *Keyboard {
-	kb := &Keyboard{ctl: ctl, events: make(chan keyEvent)}
-	go kb.run()
-	return kb
+type keyObserver struct {
+	f func(code KeyCode, state KeyState)
 }
 
-func (kb *Keyboard) ShutDown() {
-	close(kb.events)
+var (
+	kbColumnsLow  = Port(arch.PortA)
+	kbColumnsHigh = Pins{Port: arch.PortC, Mask: 0x0F}
+	kbRows        = Pins{Port: arch.PortB, Mask: 0xFC}
+	kbShift       = Pin(arch.PortB, 1)
+)
+
+// NewKeyboard connects the keyboard to the wiring. The scheduler is used to type text.
+func NewKeyboard(w *Wiring, s *Scheduler) *Keyboard {
+	kb := &Keyboard{
+		Typing: DefaultTyping,
+		pins:   w.Driver(kbColumnsLow, kbColumnsHigh, kbRows, kbShift),
+		sched:  s,
+	}
+	for _, pins := range []Pins{kbColumnsLow, kbColumnsHigh, kbRows} {
+		kb.pins.Sense(pins, func(uint64, byte) { kb.scan() })
+	}
+	return kb
 }
 
 func (kb *Keyboard) Event(code KeyCode, state KeyState) {
-	kb.events <- keyEvent{code: code, state: state}
+	for _, o := range kb.observers {
+		o.f(code, state)
+	}
+	if code == KeyShift {
+		kb.shift = state
+		kb.pins.Set(kbShift, state == KeyStateUp)
+		if state == KeyStateUp {
+			kb.mode = kbModeLat
+		}
+		return
+	}
+	if code == KeyRus && state == KeyStateDown {
+		kb.mode = kbModeRus
+	}
+	if kb.matrix.event(keyEvent{code: code, state: state}) {
+		kb.scan()
+	}
 }
 
-func (kb *Keyboard) RunSequence(seq []KeyCode) {
-	for _, code := range seq {
-		kb.Event(code, KeyStateDown)
-		kb.Event(code, KeyStateUp)
+// Observe registers f to be called on every event passed to Event, including the typed keys.
+// The returned function removes the observer.
+func (kb *Keyboard) Observe(f func(code KeyCode, state KeyState)) (unsubscribe func()) {
+	o := &keyObserver{f: f}
+	kb.observers = append(kb.observers, o)
+	return func() {
+		for i, s := range kb.observers {
+			if s == o {
+				kb.observers = append(kb.observers[:i:i], kb.observers[i+1:]...)
+				return
+			}
+		}
 	}
 }
 
-func (kb *Keyboard) run() {
-	var matrix kbMatrix
+// Scheduler returns the scheduler the keyboard was created with, its clock counts the CPU cycles.
+func (kb *Keyboard) Scheduler() *Scheduler { return kb.sched }
 
-	// Sync initial state.
-	kb.syncPorts(matrix.portValues())
+// Shift returns the state of the НР key.
+func (kb *Keyboard) Shift() KeyState { return kb.shift }
 
-	// Process events.
-	for event := range kb.events {
-		if matrix.event(event) {
-			kb.syncPorts(matrix.portValues())
-		}
+func (kb *Keyboard) RunSequence(seq []KeyCode) {
+	for _, code := range seq {
+		kb.Event(code, KeyStateDown)
+		kb.Event(code, KeyStateUp)
 	}
 }
 
-func (kb *Keyboard) syncPorts(a, b, cl byte) {
+func (kb *Keyboard) scan() {
+	a, b, cl := kb.matrix.portValues(
+		kb.pins.Others(arch.PortA),
+		kb.pins.Others(arch.PortB),
+		kb.pins.Others(arch.PortC),
+	)
 	// Columns.
-	kb.ctl.SendA(a)
-	kb.ctl.SendCLow(cl)
+	kb.pins.Drive(kbColumnsLow, a)
+	kb.pins.Drive(kbColumnsHigh, cl)
 	// Rows.
-	kb.ctl.SendB(b)
+	kb.pins.Drive(kbRows, b)
 }
 
 type keyEvent struct {
@@ -69,10 +129,19 @@ const (
 
 type KeyCode byte
 
+// KeyShift is the code of the НР key. The monitor uses it to switch back to the Latin characters.
+const KeyShift KeyCode = 0xFF
+
 func MatrixKeyCode(row, col int) KeyCode {
 	return KeyCode(byte(col&0x0F)<<4 | byte(row&0x0F))
 }
 
+// Valid reports whether the code is KeyShift or a key of the 6x12 matrix.
+func (kc KeyCode) Valid() bool {
+	r, c := kc.matrix()
+	return kc == KeyShift || r < 6 && c < 12
+}
+
 func (kc KeyCode) matrix() (r, c int) {
 	r = int(kc & 0x0F)
 	c = int(kc >> 4)
@@ -92,18 +161,31 @@ func (kb *kbMatrix) event(event keyEvent) bool {
 	return false
 }
 
-func (kb *kbMatrix) portValues() (A, B, CLow byte) {
-	rowBits, colBits := uint16(0), uint16(0)
-	for row := range kb.states {
-		for col := range kb.states[row] {
-			if kb.states[row][col] == KeyStateDown {
-				rowBits |= 1 << row
-				colBits |= 1 << col
+// portValues computes the levels of the matrix lines from the levels driven by the IO controller.
+// Low level propagates through the pressed keys, until all the connected lines are low.
+func (kb *kbMatrix) portValues(a, b, c byte) (A, B, CLow byte) {
+	// Low lines are represented with 1.
+	rowBits := uint16(^b>>2) & 0x3F
+	colBits := uint16(^a) | uint16(^c&0x0F)<<8
+
+	for changed := true; changed; {
+		changed = false
+		for row := range kb.states {
+			for col := range kb.states[row] {
+				if kb.states[row][col] != KeyStateDown {
+					continue
+				}
+				rowLow, colLow := rowBits&(1<<row) != 0, colBits&(1<<col) != 0
+				if rowLow != colLow {
+					rowBits |= 1 << row
+					colBits |= 1 << col
+					changed = true
+				}
 			}
 		}
 	}
 
-	// Down is represented with 0.
+	// Low level is represented with 0.
 	rowBits = ^rowBits
 	colBits = ^colBits
 
@@ -111,14 +193,7 @@ func (kb *kbMatrix) portValues() (A, B, CLow byte) {
 	A = byte(colBits & 0xFF)
 	// Last 4 columns are mapped to the lower C.
 	CLow = byte(colBits>>8) & 0x0F
-	// Rows are mapped to port B (row 1 - pin 7, row 6 - pin 2).
-	B = reverseBits(byte(rowBits & 0xFF))
+	// Rows are mapped to port B (row 0 - pin 2, row 5 - pin 7).
+	B = byte(rowBits<<2) | 0x03
 	return
 }
-
-func reverseBits(v byte) byte {
-	v = (v & 0xAA >> 1) | (v & 0x55 << 1)
-	v = (v & 0xCC >> 2) | (v & 0x33 << 2)
-	v = (v & 0xF0 >> 4) | (v & 0x0F << 4)
-	return v
-}
@@ -2,7 +2,6 @@ package devices
 
 import (
 	"testing"
-	"time"
 
 	"rmazur.io/fahivets/arch"
 	"rmazur.io/fahivets/internal/testutil"
@@ -14,33 +13,34 @@ func TestKeyboard(t *testing.T) {
 		ioCtrl = arch.InitIoController(&cpu)
 	)
 
-	portBComposer := NewPortComposer(ioCtrl.SendB)
-	t.Cleanup(portBComposer.ShutDown)
-
-	kbController := ComposedIoController{
-		PortA:    ioCtrl.SendA,
-		PortB:    portBComposer.MaskedSend(0xFC), // Ignore lower 2 bits.
-		PortCLow: ioCtrl.SendCLow,
-	}
-
-	kb := NewKeyboard(&kbController)
-	t.Cleanup(kb.ShutDown)
+	kb := NewKeyboard(NewWiring(ioCtrl, &cpu.Cycles), nil)
 
 	someKey := MatrixKeyCode(1, 5)
-
-	keyEventFinished := make(chan struct{})
-	go func() {
-		kb.Event(someKey, KeyStateDown)
-		close(keyEventFinished)
-	}()
+	kb.Event(someKey, KeyStateDown)
 
 	userMemStart, userMemEnd := arch.MemoryMappingRange(arch.MemUser16K)
 
 	program := arch.Program{
 		Instructions: []arch.Instruction{
-			// Program the IO controller.
+			// Drive the columns, read the rows.
+			arch.LXI(arch.RegisterPairHL, arch.MemoryIoCtrl+3), // Control byte.
+			arch.MVI(arch.RegisterSelMemory, 0x82),
+			arch.LXI(arch.RegisterPairHL, arch.MemoryIoCtrl),   // Port A.
+			arch.MVI(arch.RegisterSelMemory, 0xDF),             // Select column 5.
+			arch.LXI(arch.RegisterPairHL, arch.MemoryIoCtrl+2), // Port C.
+			arch.MVI(arch.RegisterSelMemory, 0x0F),
+
+			// Check rows.
+			arch.LDA(arch.MemoryIoCtrl + 1), // Port B.
+			arch.ANI(0xFC),                  // Ignore first 2 bits.
+			arch.CPI(0xF4),
+			arch.JCnd(arch.ConditionCodeNZ, 0), // Loop if no match.
+
+			// Drive the rows, read the columns.
 			arch.LXI(arch.RegisterPairHL, arch.MemoryIoCtrl+3), // Control byte.
-			arch.MVI(arch.RegisterSelMemory, 0x93),
+			arch.MVI(arch.RegisterSelMemory, 0x91),
+			arch.LXI(arch.RegisterPairHL, arch.MemoryIoCtrl+1), // Port B.
+			arch.MVI(arch.RegisterSelMemory, 0xF7),             // Select row 1.
 
 			// Check columns.
 			arch.LDA(arch.MemoryIoCtrl), // Port A.
@@ -51,12 +51,6 @@ func TestKeyboard(t *testing.T) {
 			arch.CPI(0x0F),
 			arch.JCnd(arch.ConditionCodeNZ, 0), // Loop if no match.
 
-			// Check rows.
-			arch.LDA(arch.MemoryIoCtrl + 1), // Port B.
-			arch.ANI(0xFC),                  // Ignore first 2 bits.
-			arch.CPI(0xBC),
-			arch.JCnd(arch.ConditionCodeNZ, 0), // Loop if no match.
-
 			// Indicate the success.
 			arch.LXI(arch.RegisterPairHL, uint16(userMemEnd-1)),
 			arch.MVI(arch.RegisterSelMemory, 1),
@@ -71,10 +65,8 @@ func TestKeyboard(t *testing.T) {
 
 	arch.EncodeInstructions(program.Instructions, cpu.Memory[userMemStart:userMemEnd/2])
 
-	ioFinished := false
-
 	for n := range 100 {
-		if ioFinished && cpu.Memory[userMemEnd-1] == 1 {
+		if cpu.Memory[userMemEnd-1] == 1 {
 			t.Logf("Reached the expected state after %d steps", n+1)
 			return
 		}
@@ -84,15 +76,6 @@ func TestKeyboard(t *testing.T) {
 			t.Fatal(err)
 		}
 		t.Logf("after %s: %s", cmd.Name, &cpu)
-		ioCtrl.Sync()
-
-		select {
-		case <-keyEventFinished:
-			ioFinished = true
-		default:
-			// Continue CPU cycle. Avoid real load.
-			time.Sleep(10 * time.Millisecond)
-		}
 	}
 
 	t.Error("program didn't complete as expected")
@@ -102,21 +85,116 @@ func TestKeyboard(t *testing.T) {
 	_ = cpu.Memory.Dump(testutil.NewTestLogWriter(t), userMemEnd-16, userMemEnd)
 }
 
-func TestReverseBits(t *testing.T) {
+func TestKeyboardScan(t *testing.T) {
 	for _, tc := range []struct {
-		x, y byte
+		name    string
+		keys    []KeyCode
+		ctl     byte
+		writes  map[uint16]byte
+		wantA   byte
+		wantB   byte // Bits 2-7.
+		wantCLo byte
 	}{
-		{0, 0},
-		{0xFF, 0xFF},
-		{0x01, 0x80},
-		{0x10, 0x08},
-		{0x80, 0x01},
-		{0x66, 0x66},
-		{0x60, 0x06},
-		{0x03, 0xC0},
+		{
+			name:   "columns/no keys",
+			ctl:    0x82,
+			writes: map[uint16]byte{0: 0xDF, 2: 0x0F},
+			wantB:  0xFC,
+		},
+		{
+			name:   "columns/same column",
+			keys:   []KeyCode{MatrixKeyCode(1, 5), MatrixKeyCode(3, 5), MatrixKeyCode(2, 6)},
+			ctl:    0x82,
+			writes: map[uint16]byte{0: 0xDF, 2: 0x0F},
+			wantB:  0xD4,
+		},
+		{
+			name:   "columns/high column",
+			keys:   []KeyCode{MatrixKeyCode(0, 9)},
+			ctl:    0x82,
+			writes: map[uint16]byte{0: 0xFF, 2: 0x0D},
+			wantB:  0xF8,
+		},
+		{
+			name:   "columns/ghosting",
+			keys:   []KeyCode{MatrixKeyCode(1, 5), MatrixKeyCode(1, 9), MatrixKeyCode(3, 9)},
+			ctl:    0x82,
+			writes: map[uint16]byte{0: 0xDF, 2: 0x0F},
+			wantB:  0xD4,
+		},
+		{
+			name:    "rows",
+			keys:    []KeyCode{MatrixKeyCode(2, 10), MatrixKeyCode(2, 0), MatrixKeyCode(4, 1)},
+			ctl:     0x91,
+			writes:  map[uint16]byte{1: 0xEF},
+			wantA:   0xFE,
+			wantCLo: 0x0B,
+		},
+		{
+			name:    "rows/ghosting",
+			keys:    []KeyCode{MatrixKeyCode(2, 0), MatrixKeyCode(4, 0), MatrixKeyCode(4, 1)},
+			ctl:     0x91,
+			writes:  map[uint16]byte{1: 0xEF},
+			wantA:   0xFC,
+			wantCLo: 0x0F,
+		},
 	} {
-		if got, want := reverseBits(tc.x), tc.y; got != want {
-			t.Errorf("reverseBits(%08b) = %08b; want %08b", tc.x, got, want)
+		t.Run(tc.name, func(t *testing.T) {
+			var cpu arch.CPU
+			kb := NewKeyboard(NewWiring(arch.InitIoController(&cpu), &cpu.Cycles), nil)
+			for _, k := range tc.keys {
+				kb.Event(k, KeyStateDown)
+			}
+			cpu.Write(arch.MemoryIoCtrl+3, tc.ctl)
+			for offset, v := range tc.writes {
+				cpu.Write(arch.MemoryIoCtrl+offset, v)
+			}
+
+			if tc.ctl == 0x82 {
+				if b := cpu.Read(arch.MemoryIoCtrl+1) & 0xFC; b != tc.wantB {
+					t.Errorf("got rows 0x%02x; want 0x%02x", b, tc.wantB)
+				}
+			} else {
+				if a := cpu.Read(arch.MemoryIoCtrl); a != tc.wantA {
+					t.Errorf("got port A 0x%02x; want 0x%02x", a, tc.wantA)
+				}
+				if c := cpu.Read(arch.MemoryIoCtrl+2) & 0x0F; c != tc.wantCLo {
+					t.Errorf("got lower port C 0x%02x; want 0x%02x", c, tc.wantCLo)
+				}
+			}
+
+			// Releasing the keys releases the lines.
+			for _, k := range tc.keys {
+				kb.Event(k, KeyStateUp)
+			}
+			if b := cpu.Read(arch.MemoryIoCtrl+1) & 0xFC; tc.ctl == 0x82 && b != 0xFC {
+				t.Errorf("got rows 0x%02x after release; want 0xfc", b)
+			}
+			if a := cpu.Read(arch.MemoryIoCtrl); tc.ctl == 0x91 && a != 0xFF {
+				t.Errorf("got port A 0x%02x after release; want 0xff", a)
+			}
+		})
+	}
+}
+
+func TestKeyboardShift(t *testing.T) {
+	var cpu arch.CPU
+	kb := NewKeyboard(NewWiring(arch.InitIoController(&cpu), &cpu.Cycles), nil)
+	cpu.Write(arch.MemoryIoCtrl+3, 0x82)
+
+	for _, tc := range []struct {
+		state KeyState
+		wantB byte
+	}{
+		{KeyStateDown, 0xFD},
+		{KeyStateUp, 0xFF},
+	} {
+		kb.Event(KeyShift, tc.state)
+		if b := cpu.Read(arch.MemoryIoCtrl + 1); b != tc.wantB {
+			t.Errorf("got port B 0x%02x with НР %v; want 0x%02x", b, tc.state, tc.wantB)
+		}
+		if kb.Shift() != tc.state {
+			t.Errorf("got shift state %v; want %v", kb.Shift(), tc.state)
 		}
 	}
 }
@@ -127,3 +205,16 @@ func TestMatrixKeyCode(t *testing.T) {
 		t.Errorf("MatrixKeyCode(4, 2).matrix() = (%d, %d); want (%d, %d)", r, c, 4, 2)
 	}
 }
+
+func TestKeyboardObserve(t *testing.T) {
+	var cpu arch.CPU
+	kb := NewKeyboard(NewWiring(arch.InitIoController(&cpu), &cpu.Cycles), nil)
+	var events []KeyState
+	unsubscribe := kb.Observe(func(_ KeyCode, state KeyState) { events = append(events, state) })
+	kb.Event(MatrixKeyCode(1, 1), KeyStateDown)
+	unsubscribe()
+	kb.Event(MatrixKeyCode(1, 1), KeyStateUp)
+	if len(events) != 1 || events[0] != KeyStateDown {
+		t.Errorf("got events %v; want only the key down", events)
+	}
+}
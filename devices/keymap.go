@@ -0,0 +1,238 @@
+package devices
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// KeyBinding is a Фахівець key a host key is mapped to.
+type KeyBinding struct {
+	Key KeyCode
+	// Shift requires the НР key to be held while the key is pressed.
+	Shift bool
+}
+
+// Keymap maps the host keys to the Фахівець keys.
+// Keys are identified either with the host key codes (physical positions, like "KeyQ" in the browsers),
+// or with the characters they produce in the host layout. Characters take precedence.
+//
+// Keymaps are stored in JSON:
+//
+//	{
+//	  "name": "Description",
+//	  "extends": "positional",
+//	  "codes": {"KeyQ": "3,11", "ShiftLeft": "НР", "CapsLock": "РУС", "AltLeft": ""},
+//	  "chars": {"Й": "3,11", "!": "НР+4,10"}
+//	}
+//
+// Targets are matrix positions "row,col", "НР" or "РУС", optionally prefixed with "НР+" to press НР together
+// with the key. An empty target removes the binding inherited from the extended built-in keymap.
+// Characters are case-insensitive.
+type Keymap struct {
+	Name  string
+	codes map[string]KeyBinding
+	chars map[string]KeyBinding
+}
+
+// Lookup finds the binding for the host key code, or the character it produces.
+func (km *Keymap) Lookup(code, char string) (KeyBinding, bool) {
+	if b, ok := km.chars[strings.ToUpper(char)]; ok {
+		return b, true
+	}
+	b, ok := km.codes[code]
+	return b, ok
+}
+
+//go:embed keymaps/*.json
+var keymapsFS embed.FS
+
+// DefaultKeymap is the name of the built-in keymap that preserves the positions of the keys.
+const DefaultKeymap = "positional"
+
+// Keymaps returns the names of the built-in keymaps.
+func Keymaps() []string {
+	entries, _ := keymapsFS.ReadDir("keymaps")
+	var res []string
+	for _, e := range entries {
+		res = append(res, strings.TrimSuffix(e.Name(), ".json"))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// BuiltinKeymap returns the built-in keymap with the specified name.
+func BuiltinKeymap(name string) (*Keymap, error) {
+	f, err := keymapsFS.Open(path.Join("keymaps", name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown keymap %q", name)
+	}
+	defer f.Close()
+	return ReadKeymap(f)
+}
+
+type keymapFile struct {
+	Name    string            `json:"name"`
+	Extends string            `json:"extends"`
+	Codes   map[string]string `json:"codes"`
+	Chars   map[string]string `json:"chars"`
+}
+
+// ReadKeymap parses the keymap JSON.
+func ReadKeymap(in io.Reader) (*Keymap, error) {
+	var file keymapFile
+	if err := json.NewDecoder(in).Decode(&file); err != nil {
+		return nil, fmt.Errorf("cannot parse keymap: %w", err)
+	}
+
+	km := &Keymap{Name: file.Name, codes: make(map[string]KeyBinding), chars: make(map[string]KeyBinding)}
+	if file.Extends != "" {
+		base, err := BuiltinKeymap(file.Extends)
+		if err != nil {
+			return nil, err
+		}
+		for k, b := range base.codes {
+			km.codes[k] = b
+		}
+		for k, b := range base.chars {
+			km.chars[k] = b
+		}
+	}
+
+	for code, target := range file.Codes {
+		if err := km.bind(km.codes, code, target); err != nil {
+			return nil, err
+		}
+	}
+	for char, target := range file.Chars {
+		if utf8.RuneCountInString(char) != 1 {
+			return nil, fmt.Errorf("keymap char %q is not a single character", char)
+		}
+		if err := km.bind(km.chars, strings.ToUpper(char), target); err != nil {
+			return nil, err
+		}
+	}
+	return km, nil
+}
+
+func (km *Keymap) bind(m map[string]KeyBinding, key, target string) error {
+	if target == "" {
+		delete(m, key)
+		return nil
+	}
+	b, err := ParseKeyBinding(target)
+	if err != nil {
+		return fmt.Errorf("keymap key %q: %w", key, err)
+	}
+	m[key] = b
+	return nil
+}
+
+// ParseKeyBinding parses the keymap target: "row,col", "НР" or "РУС", optionally prefixed with "НР+".
+func ParseKeyBinding(target string) (b KeyBinding, err error) {
+	if rest, ok := strings.CutPrefix(target, "НР+"); ok {
+		b.Shift, target = true, rest
+	}
+	switch target {
+	case "НР":
+		b.Key = KeyShift
+		return
+	case "РУС":
+		b.Key = KeyRus
+		return
+	}
+	rs, cs, ok := strings.Cut(target, ",")
+	row, rErr := strconv.Atoi(strings.TrimSpace(rs))
+	col, cErr := strconv.Atoi(strings.TrimSpace(cs))
+	if !ok || rErr != nil || cErr != nil || row < 0 || row >= 6 || col < 0 || col >= 12 {
+		return b, fmt.Errorf("bad target %q", target)
+	}
+	b.Key = MatrixKeyCode(row, col)
+	return
+}
+
+// KeymapInput translates the host key events to the keyboard events using a keymap.
+// Keys are released with the binding chosen when they were pressed, so changes of the character
+// produced by a held key do not leave the Фахівець keys pressed.
+type KeymapInput struct {
+	kb      *Keyboard
+	km      *Keymap
+	pressed map[string]KeyBinding
+	shifts  int
+}
+
+// NewKeymapInput creates the input for the keyboard.
+func NewKeymapInput(kb *Keyboard, km *Keymap) *KeymapInput {
+	return &KeymapInput{kb: kb, km: km, pressed: make(map[string]KeyBinding)}
+}
+
+// SetKeymap releases all the pressed keys and switches to another keymap.
+func (in *KeymapInput) SetKeymap(km *Keymap) {
+	for code := range in.pressed {
+		in.Release(code)
+	}
+	in.km = km
+}
+
+// Keymap returns the current keymap.
+func (in *KeymapInput) Keymap() *Keymap { return in.km }
+
+// Press handles a host key press. It returns false if the key is not mapped.
+func (in *KeymapInput) Press(code, char string) bool {
+	if _, repeat := in.pressed[code]; repeat {
+		return true
+	}
+	b, ok := in.km.Lookup(code, char)
+	if !ok {
+		return false
+	}
+	in.PressBinding(code, b)
+	return true
+}
+
+// PressBinding handles a host key press bypassing the keymap. Frontends use it for their own hotkeys.
+// The key is released with Release.
+func (in *KeymapInput) PressBinding(code string, b KeyBinding) {
+	if _, repeat := in.pressed[code]; repeat {
+		return
+	}
+	in.pressed[code] = b
+	if b.Shift || b.Key == KeyShift {
+		in.shift(1)
+	}
+	if b.Key != KeyShift {
+		in.kb.Event(b.Key, KeyStateDown)
+	}
+}
+
+// Release handles a host key release.
+func (in *KeymapInput) Release(code string) {
+	b, ok := in.pressed[code]
+	if !ok {
+		return
+	}
+	delete(in.pressed, code)
+	if b.Key != KeyShift {
+		in.kb.Event(b.Key, KeyStateUp)
+	}
+	if b.Shift || b.Key == KeyShift {
+		in.shift(-1)
+	}
+}
+
+// shift tracks the keys holding НР.
+func (in *KeymapInput) shift(delta int) {
+	in.shifts += delta
+	switch {
+	case delta > 0 && in.shifts == 1:
+		in.kb.Event(KeyShift, KeyStateDown)
+	case delta < 0 && in.shifts == 0:
+		in.kb.Event(KeyShift, KeyStateUp)
+	}
+}
@@ -0,0 +1,114 @@
+package devices
+
+import (
+	"strings"
+	"testing"
+
+	"rmazur.io/fahivets/arch"
+)
+
+func TestBuiltinKeymaps(t *testing.T) {
+	names := Keymaps()
+	if len(names) != 4 {
+		t.Errorf("got keymaps %v; want 4", names)
+	}
+	for _, name := range names {
+		km, err := BuiltinKeymap(name)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		// Every keymap provides the keys that cannot be typed as characters.
+		if b, ok := km.Lookup("Enter", "Enter"); !ok || b.Key != MatrixKeyCode(0, 0) {
+			t.Errorf("%s: got Enter %+v, %t; want 0,0", name, b, ok)
+		}
+	}
+	if _, err := BuiltinKeymap("dvorak"); err == nil {
+		t.Error("expected an error for an unknown keymap")
+	}
+
+	for _, tc := range []struct {
+		keymap, code, char string
+		want               KeyBinding
+		ok                 bool
+	}{
+		{keymap: "positional", code: "KeyQ", char: "q", want: KeyBinding{Key: MatrixKeyCode(3, 11)}, ok: true},
+		{keymap: "positional", code: "ShiftLeft", char: "Shift", want: KeyBinding{Key: KeyShift}, ok: true},
+		{keymap: "positional", code: "CapsLock", char: "CapsLock", want: KeyBinding{Key: KeyRus}, ok: true},
+		{keymap: "positional", code: "AltLeft", char: "Alt"},
+		{keymap: "ukrainian", code: "KeyQ", char: "й", want: KeyBinding{Key: MatrixKeyCode(3, 11)}, ok: true},
+		{keymap: "ukrainian", code: "KeyS", char: "і", want: KeyBinding{Key: MatrixKeyCode(1, 7)}, ok: true},
+		{keymap: "ukrainian", code: "KeyQ", char: "q", want: KeyBinding{Key: MatrixKeyCode(1, 11)}, ok: true},
+		{keymap: "ukrainian", code: "Digit1", char: "!", want: KeyBinding{Key: MatrixKeyCode(4, 10), Shift: true}, ok: true},
+		{keymap: "ukrainian", code: "Slash", char: ".", want: KeyBinding{Key: MatrixKeyCode(2, 0)}, ok: true},
+		{keymap: "ukrainian", code: "ShiftLeft", char: "Shift"},
+		{keymap: "russian", code: "KeyS", char: "Ы", want: KeyBinding{Key: MatrixKeyCode(2, 10)}, ok: true},
+		{keymap: "games", code: "KeyW", char: "w", want: KeyBinding{Key: MatrixKeyCode(0, 9)}, ok: true},
+		{keymap: "games", code: "KeyE", char: "e", want: KeyBinding{Key: MatrixKeyCode(3, 9)}, ok: true},
+	} {
+		km, _ := BuiltinKeymap(tc.keymap)
+		got, ok := km.Lookup(tc.code, tc.char)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%s %s/%s: got %+v, %t; want %+v, %t", tc.keymap, tc.code, tc.char, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestReadKeymap(t *testing.T) {
+	km, err := ReadKeymap(strings.NewReader(`{"extends": "games", "codes": {"KeyW": "", "KeyX": "НР+1,2"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := km.Lookup("KeyW", ""); ok {
+		t.Error("KeyW must be removed")
+	}
+	if b, _ := km.Lookup("KeyX", ""); b != (KeyBinding{Key: MatrixKeyCode(1, 2), Shift: true}) {
+		t.Errorf("got KeyX %+v", b)
+	}
+
+	for _, bad := range []string{
+		`{"codes": {"KeyA": "6,0"}}`,
+		`{"codes": {"KeyA": "A"}}`,
+		`{"chars": {"ab": "1,1"}}`,
+		`{"extends": "unknown"}`,
+		`[]`,
+	} {
+		if _, err := ReadKeymap(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestKeymapInput(t *testing.T) {
+	var cpu arch.CPU
+	kb := NewKeyboard(NewWiring(arch.InitIoController(&cpu), &cpu.Cycles), nil)
+	km, _ := BuiltinKeymap("russian")
+	in := NewKeymapInput(kb, km)
+
+	one := MatrixKeyCode(4, 10)
+	if !in.Press("Digit1", "!") {
+		t.Fatal("! is not mapped")
+	}
+	if kb.Shift() != KeyStateDown || kb.matrix.states[4][10] != KeyStateDown {
+		t.Error("НР and 1 must be pressed for !")
+	}
+	in.Press("AltLeft", "Alt") // НР held on its own too.
+	in.Release("Digit1")
+	if kb.Shift() != KeyStateDown || kb.matrix.states[4][10] != KeyStateUp {
+		t.Error("only НР must be pressed")
+	}
+	in.Release("AltLeft")
+	if kb.Shift() != KeyStateUp {
+		t.Error("НР must be released")
+	}
+
+	if in.Press("F13", "F13") {
+		t.Error("F13 must not be mapped")
+	}
+
+	in.Press("Digit1", "1")
+	positional, _ := BuiltinKeymap(DefaultKeymap)
+	in.SetKeymap(positional)
+	if r, c := one.matrix(); kb.matrix.states[r][c] != KeyStateUp {
+		t.Error("keys must be released when the keymap changes")
+	}
+}
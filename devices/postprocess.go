@@ -0,0 +1,238 @@
+package devices
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tints of the monochrome display.
+var (
+	MonoWhite = color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+	MonoGreen = color.RGBA{R: 0x33, G: 0xFF, B: 0x66, A: 0xFF}
+	MonoAmber = color.RGBA{R: 0xFF, G: 0xB0, B: 0x00, A: 0xFF}
+)
+
+// MonoPalettes are the named tints for PostOptions.Set.
+var MonoPalettes = map[string]color.RGBA{"white": MonoWhite, "green": MonoGreen, "amber": MonoAmber}
+
+// PostOptions configure the PostProcessor.
+type PostOptions struct {
+	// Palette is the tint of the monochrome display. It's not applied to the colour displays.
+	Palette color.RGBA
+	// Scale is the integer scale of the display height.
+	Scale int
+	// Aspect stretches the display to 4:3, the way it looks on a TV set. Otherwise the pixels are square.
+	Aspect bool
+	// Smooth uses bilinear filtering instead of the nearest neighbour.
+	Smooth bool
+	// Scanlines darkens the lower half of every display line by the factor from 0 to 1.
+	// It's visible with Scale 2 or more.
+	Scanlines float64
+	// Persistence keeps the fading image of the previous frames: every frame the previous image intensity
+	// is multiplied by the factor from 0 to 1.
+	Persistence float64
+}
+
+// DefaultPostOptions returns the options of the plain 2x scaled display.
+func DefaultPostOptions() PostOptions { return PostOptions{Palette: MonoWhite, Scale: 2} }
+
+// Set sets the option by its name: palette (white, green, amber), scale, aspect, smooth, scanlines, persistence.
+// Frontends use it to configure the options from flags or URL parameters.
+func (o *PostOptions) Set(name, value string) error {
+	var err error
+	switch name {
+	case "palette":
+		p, ok := MonoPalettes[value]
+		if !ok {
+			names := make([]string, 0, len(MonoPalettes))
+			for n := range MonoPalettes {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown palette %q, want one of %s", value, strings.Join(names, ", "))
+		}
+		o.Palette = p
+	case "scale":
+		o.Scale, err = strconv.Atoi(value)
+		if err == nil && (o.Scale < 1 || o.Scale > 8) {
+			err = fmt.Errorf("scale must be from 1 to 8")
+		}
+	case "aspect":
+		o.Aspect, err = strconv.ParseBool(value)
+	case "smooth":
+		o.Smooth, err = strconv.ParseBool(value)
+	case "scanlines":
+		o.Scanlines, err = parseFactor(value)
+	case "persistence":
+		o.Persistence, err = parseFactor(value)
+	default:
+		return fmt.Errorf("unknown display option %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("display option %s: %w", name, err)
+	}
+	return nil
+}
+
+func parseFactor(value string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err == nil && (v < 0 || v > 1) {
+		err = fmt.Errorf("%s is out of range from 0 to 1", value)
+	}
+	return v, err
+}
+
+// PostProcessor converts the display frames into the images shown by the frontends: it tints, scales, and
+// blends them. It updates a persistent image, processing only the regions that changed.
+type PostProcessor struct {
+	opts       PostOptions
+	monochrome bool
+	src        image.Rectangle
+	out        *image.RGBA
+
+	processed bool
+	// decaying is set while the persistence blending still changes the image.
+	decaying bool
+}
+
+// NewPostProcessor creates the post processor for the frames of the display.
+func NewPostProcessor(opts PostOptions, display *Display) *PostProcessor {
+	if opts.Scale < 1 {
+		opts.Scale = 1
+	}
+	if opts.Palette == (color.RGBA{}) {
+		opts.Palette = MonoWhite
+	}
+	src := display.Bounds()
+	h := src.Dy() * opts.Scale
+	w := src.Dx() * opts.Scale
+	if opts.Aspect {
+		w = h * 4 / 3
+	}
+	return &PostProcessor{
+		opts:       opts,
+		monochrome: display.ColorMode() == ColorNone,
+		src:        src,
+		out:        image.NewRGBA(image.Rect(0, 0, w, h)),
+	}
+}
+
+// Options returns the post processing options.
+func (p *PostProcessor) Options() PostOptions { return p.opts }
+
+// Process updates the output image with the frame produced by DisplayRenderer at scale 1.
+// It returns the output image and its changed regions.
+func (p *PostProcessor) Process(frame *image.RGBA, dirty []image.Rectangle) (*image.RGBA, []image.Rectangle) {
+	var regions []image.Rectangle
+	switch {
+	case !p.processed || p.opts.Persistence > 0 && (p.decaying || len(dirty) > 0):
+		regions = []image.Rectangle{p.out.Bounds()}
+	default:
+		for _, r := range dirty {
+			regions = append(regions, p.outRect(r))
+		}
+	}
+	p.processed = true
+	p.decaying = false
+
+	var changed []image.Rectangle
+	for _, r := range regions {
+		if c := p.processRegion(frame, r); !c.Empty() {
+			changed = append(changed, c)
+		}
+	}
+	return p.out, changed
+}
+
+// outRect maps the source rectangle to the output one, with a margin for the filtering.
+func (p *PostProcessor) outRect(r image.Rectangle) image.Rectangle {
+	ob := p.out.Bounds()
+	sw, sh := p.src.Dx(), p.src.Dy()
+	res := image.Rect(
+		r.Min.X*ob.Dx()/sw-1, r.Min.Y*ob.Dy()/sh-1,
+		(r.Max.X*ob.Dx()+sw-1)/sw+1, (r.Max.Y*ob.Dy()+sh-1)/sh+1,
+	)
+	return res.Intersect(ob)
+}
+
+// processRegion redraws the region of the output image and returns the bounds of the changed pixels.
+func (p *PostProcessor) processRegion(frame *image.RGBA, region image.Rectangle) image.Rectangle {
+	var changed image.Rectangle
+	ob := p.out.Bounds()
+	sw, sh := float64(p.src.Dx()), float64(p.src.Dy())
+	kx, ky := sw/float64(ob.Dx()), sh/float64(ob.Dy())
+	tint := [3]float64{float64(p.opts.Palette.R) / 255, float64(p.opts.Palette.G) / 255, float64(p.opts.Palette.B) / 255}
+
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		shade := 1.0
+		if (y*p.src.Dy())%ob.Dy()*2 >= ob.Dy() {
+			shade -= p.opts.Scanlines
+		}
+		for x := region.Min.X; x < region.Max.X; x++ {
+			var c [3]float64
+			if p.opts.Smooth {
+				c = bilinear(frame, (float64(x)+0.5)*kx-0.5, (float64(y)+0.5)*ky-0.5)
+			} else {
+				c = pixelAt(frame, int(float64(x)*kx), int(float64(y)*ky))
+			}
+			if p.monochrome {
+				lum := (c[0] + c[1] + c[2]) / 3
+				c = [3]float64{lum * tint[0], lum * tint[1], lum * tint[2]}
+			}
+
+			pix := p.out.Pix[p.out.PixOffset(x, y):]
+			pixelChanged := false
+			for i := range c {
+				b := byte(c[i]*shade + 0.5)
+				// The truncation guarantees the decay reaches zero.
+				if prev := byte(float64(pix[i]) * p.opts.Persistence); prev > b {
+					b = prev
+					p.decaying = true
+				}
+				if b != pix[i] {
+					pix[i] = b
+					pixelChanged = true
+				}
+			}
+			if pix[3] != 0xFF {
+				pix[3] = 0xFF
+				pixelChanged = true
+			}
+			if pixelChanged {
+				changed = changed.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return changed
+}
+
+func pixelAt(img *image.RGBA, x, y int) [3]float64 {
+	b := img.Bounds()
+	x, y = min(max(x, b.Min.X), b.Max.X-1), min(max(y, b.Min.Y), b.Max.Y-1)
+	pix := img.Pix[img.PixOffset(x, y):]
+	return [3]float64{float64(pix[0]), float64(pix[1]), float64(pix[2])}
+}
+
+func bilinear(img *image.RGBA, fx, fy float64) [3]float64 {
+	x0, y0 := int(fx), int(fy)
+	if fx < 0 {
+		x0 = -1
+	}
+	if fy < 0 {
+		y0 = -1
+	}
+	ax, ay := fx-float64(x0), fy-float64(y0)
+	c00, c10 := pixelAt(img, x0, y0), pixelAt(img, x0+1, y0)
+	c01, c11 := pixelAt(img, x0, y0+1), pixelAt(img, x0+1, y0+1)
+	var res [3]float64
+	for i := range res {
+		top := c00[i]*(1-ax) + c10[i]*ax
+		bottom := c01[i]*(1-ax) + c11[i]*ax
+		res[i] = top*(1-ay) + bottom*ay
+	}
+	return res
+}
@@ -0,0 +1,130 @@
+package devices
+
+import (
+	"image"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"rmazur.io/fahivets/arch"
+	"rmazur.io/fahivets/internal/testutil"
+)
+
+// testPattern draws a frame, a diagonal line, and a checker board in the top left corner of the display.
+func testPattern(cpu *arch.CPU) {
+	start, _ := arch.MemoryMappingRange(arch.MemDisplay12K)
+	mem := cpu.Memory[start:]
+	for col := range 8 {
+		mem[col*DisplayHeight] = 0xFF
+		mem[col*DisplayHeight+63] = 0xFF
+	}
+	for y := range 64 {
+		mem[y] |= 0x80
+		mem[7*DisplayHeight+y] |= 0x01
+		mem[y/8*DisplayHeight+y] |= 0x80 >> (y % 8)
+		if y >= 16 && y < 48 {
+			mem[5*DisplayHeight+y] = 0xAA >> (y % 2)
+		}
+	}
+}
+
+func checkGolden(t *testing.T, name string, img image.Image) {
+	t.Helper()
+	testutil.CheckImage(t, filepath.Join("testdata", "postprocess", name+".png"), img, testutil.ImageOptions{})
+}
+
+func TestPostProcessor(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts PostOptions
+		size image.Point
+	}{
+		{name: "white", opts: DefaultPostOptions(), size: image.Pt(768, 512)},
+		{name: "green", opts: PostOptions{Palette: MonoGreen, Scale: 1}, size: image.Pt(384, 256)},
+		{name: "amber-aspect", opts: PostOptions{Palette: MonoAmber, Scale: 1, Aspect: true}, size: image.Pt(341, 256)},
+		{name: "smooth-aspect", opts: PostOptions{Scale: 2, Aspect: true, Smooth: true}, size: image.Pt(682, 512)},
+		{name: "scanlines", opts: PostOptions{Scale: 2, Scanlines: 0.5}, size: image.Pt(768, 512)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cpu arch.CPU
+			d := NewDisplay(&cpu)
+			testPattern(&cpu)
+			p := NewPostProcessor(tc.opts, d)
+			img, dirty := p.Process(d.NewRenderer(1).Render())
+			if img.Bounds().Size() != tc.size {
+				t.Errorf("got size %v; want %v", img.Bounds().Size(), tc.size)
+			}
+			if len(dirty) == 0 {
+				t.Error("got no dirty regions in the first frame")
+			}
+			checkGolden(t, tc.name, img)
+		})
+	}
+}
+
+func TestPostProcessorUpdates(t *testing.T) {
+	var cpu arch.CPU
+	d := NewDisplay(&cpu)
+	start, _ := arch.MemoryMappingRange(arch.MemDisplay12K)
+	r := d.NewRenderer(1)
+
+	p := NewPostProcessor(DefaultPostOptions(), d)
+	p.Process(r.Render())
+	if _, dirty := p.Process(r.Render()); len(dirty) != 0 {
+		t.Errorf("got dirty %v without changes", dirty)
+	}
+	cpu.Memory[start+DisplayHeight+10] = 0x01 // Column 1, line 10.
+	img, dirty := p.Process(r.Render())
+	if want := []image.Rectangle{image.Rect(30, 20, 32, 22)}; !slices.Equal(dirty, want) {
+		t.Errorf("got dirty %v; want %v", dirty, want)
+	}
+	if c := img.RGBAAt(31, 21); c != MonoWhite {
+		t.Errorf("got %v; want %v", c, MonoWhite)
+	}
+
+	t.Run("persistence", func(t *testing.T) {
+		var cpu arch.CPU
+		d := NewDisplay(&cpu)
+		testPattern(&cpu)
+		r := d.NewRenderer(1)
+		p := NewPostProcessor(PostOptions{Palette: MonoGreen, Scale: 1, Persistence: 0.5}, d)
+		p.Process(r.Render())
+
+		// The pattern is erased and fades out.
+		clear(cpu.Memory[start : start+8*DisplayHeight])
+		img, _ := p.Process(r.Render())
+		checkGolden(t, "persistence", img)
+		if c := img.RGBAAt(0, 0); c.G != 0x7F {
+			t.Errorf("got %v after the first frame; want the half of the green", c)
+		}
+		frames := 1
+		for ; frames < 20; frames++ {
+			if _, dirty := p.Process(r.Render()); len(dirty) == 0 {
+				break
+			}
+		}
+		if frames != 8 {
+			t.Errorf("got the image faded out in %d frames; want 8", frames)
+		}
+		if c := img.RGBAAt(0, 0); c.G != 0 {
+			t.Errorf("got %v after fading out; want black", c)
+		}
+	})
+
+	t.Run("color", func(t *testing.T) {
+		var cpu arch.CPU
+		arch.InitIoController(&cpu)
+		d := NewColorDisplay(&cpu, ColorRegister, Palette16)
+		cpu.Write(ColorRegisterAddr, 0x41)
+		cpu.Write(uint16(start), 0xF0)
+		p := NewPostProcessor(PostOptions{Palette: MonoAmber, Scale: 1}, d)
+		img, _ := p.Process(d.NewRenderer(1).Render())
+		// The palette does not tint the colour display.
+		if c := img.RGBAAt(0, 0); c != Palette16[4] {
+			t.Errorf("got ink %v; want %v", c, Palette16[4])
+		}
+		if c := img.RGBAAt(7, 0); c != Palette16[1] {
+			t.Errorf("got paper %v; want %v", c, Palette16[1])
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package devices
+
+import "container/heap"
+
+// Scheduler executes device events at the specified CPU cycles.
+// Events are fired between the instructions, at the first instruction boundary when the cycle counter reaches
+// the event timestamp. Events with the same timestamp fire in the order they were scheduled.
+// The scheduler is not safe for concurrent use, it's supposed to be used from the routine that runs the CPU.
+type Scheduler struct {
+	clock  *uint64
+	events eventQueue
+	seq    uint64
+}
+
+// NewScheduler creates a scheduler that uses the clock value as the current cycle.
+func NewScheduler(clock *uint64) *Scheduler {
+	return &Scheduler{clock: clock}
+}
+
+// Now returns the current cycle.
+func (s *Scheduler) Now() uint64 { return *s.clock }
+
+// At schedules f to be called at the cycle. Events scheduled in the past fire on the next Run.
+func (s *Scheduler) At(cycle uint64, f func()) *Event {
+	e := &Event{at: cycle, seq: s.seq, f: f}
+	s.seq++
+	heap.Push(&s.events, e)
+	return e
+}
+
+// After schedules f to be called after the specified number of cycles from now.
+func (s *Scheduler) After(cycles uint64, f func()) *Event { return s.At(s.Now()+cycles, f) }
+
+// Next returns the timestamp of the nearest pending event.
+func (s *Scheduler) Next() (cycle uint64, ok bool) {
+	for len(s.events) > 0 {
+		if e := s.events[0]; !e.cancelled {
+			return e.at, true
+		}
+		heap.Pop(&s.events)
+	}
+	return 0, false
+}
+
+// Run fires all the events that are due.
+func (s *Scheduler) Run() {
+	for len(s.events) > 0 && s.events[0].at <= *s.clock {
+		e := heap.Pop(&s.events).(*Event)
+		if !e.cancelled {
+			e.cancelled = true
+			e.f()
+		}
+	}
+}
+
+// Event is a callback registered in the Scheduler.
+type Event struct {
+	at, seq   uint64
+	f         func()
+	cancelled bool
+}
+
+// Cycle returns the event timestamp.
+func (e *Event) Cycle() uint64 { return e.at }
+
+// Cancel prevents the event from firing. It has no effect on the events that have already fired.
+func (e *Event) Cancel() { e.cancelled = true }
+
+type eventQueue []*Event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x any) { *q = append(*q, x.(*Event)) }
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,52 @@
+package devices
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestScheduler(t *testing.T) {
+	var (
+		clock uint64
+		s     = NewScheduler(&clock)
+		fired []string
+	)
+	record := func(name string) func() {
+		return func() { fired = append(fired, name) }
+	}
+
+	s.At(10, record("a"))
+	s.At(5, record("b"))
+	s.At(10, record("c"))
+	cancelled := s.At(7, record("cancelled"))
+	s.After(20, func() {
+		fired = append(fired, "d")
+		// Events scheduled from the callbacks fire in the same run if they are due.
+		s.At(clock, record("e"))
+	})
+	cancelled.Cancel()
+
+	if next, ok := s.Next(); !ok || next != 5 {
+		t.Errorf("Next() = %d, %t; want 5, true", next, ok)
+	}
+
+	for _, tc := range []struct {
+		clock uint64
+		want  []string
+	}{
+		{clock: 4, want: nil},
+		{clock: 5, want: []string{"b"}},
+		{clock: 12, want: []string{"b", "a", "c"}},
+		{clock: 25, want: []string{"b", "a", "c", "d", "e"}},
+	} {
+		clock = tc.clock
+		s.Run()
+		if !slices.Equal(fired, tc.want) {
+			t.Errorf("at %d: got %v; want %v", tc.clock, fired, tc.want)
+		}
+	}
+
+	if _, ok := s.Next(); ok {
+		t.Error("unexpected pending events")
+	}
+}
@@ -0,0 +1,118 @@
+package devices
+
+import (
+	"io"
+
+	"rmazur.io/fahivets/arch"
+	"rmazur.io/fahivets/internal/wav"
+)
+
+// Speaker simulates the beeper connected to the pin 5 of port C.
+// Every change of the pin is timestamped with the CPU cycle, and the signal is rendered into PCM samples.
+// Each sample is the average level of the pin over the sample period, which works as a low-pass filter and
+// prevents the aliasing of the square wave. The constant level is removed with a DC blocker, so a silent speaker
+// produces zeros regardless of the pin state.
+type Speaker struct {
+	sampleRate      int
+	cyclesPerSample float64
+
+	level bool
+	// Cycle position up to which the signal is integrated.
+	pos float64
+	// End of the current sample period.
+	sampleEnd float64
+	acc       float64
+
+	// DC blocker state.
+	prevIn, prevOut float64
+
+	samples []float32
+}
+
+const (
+	// SpeakerPin is the bit of port C the speaker is connected to.
+	SpeakerPin = 0x20
+
+	speakerAmplitude = 0.5
+	speakerDCBlocker = 0.995
+	// Samples are dropped if nobody pulls them for too long.
+	speakerMaxBufferedSeconds = 1
+)
+
+// NewSpeaker creates a speaker that renders samples at sampleRate for the CPU running at clockFrequency cycles per second.
+func NewSpeaker(clockFrequency, sampleRate int) *Speaker {
+	s := &Speaker{
+		sampleRate:      sampleRate,
+		cyclesPerSample: float64(clockFrequency) / float64(sampleRate),
+	}
+	s.sampleEnd = s.cyclesPerSample
+	return s
+}
+
+// Connect makes the speaker sense its pin.
+func (s *Speaker) Connect(w *Wiring) {
+	w.Sense(Pins{Port: arch.PortC, Mask: SpeakerPin}, func(cycle uint64, v byte) {
+		s.Update(cycle, v&SpeakerPin != 0)
+	})
+}
+
+// SampleRate returns the sample rate of the generated PCM stream.
+func (s *Speaker) SampleRate() int { return s.sampleRate }
+
+// Update sets the pin state at the given CPU cycle.
+// It's supposed to be called with non-decreasing cycle values.
+func (s *Speaker) Update(cycle uint64, on bool) {
+	s.integrate(float64(cycle))
+	s.level = on
+}
+
+// Advance renders the samples up to the given CPU cycle keeping the pin state.
+func (s *Speaker) Advance(cycle uint64) { s.integrate(float64(cycle)) }
+
+func (s *Speaker) integrate(to float64) {
+	value := -speakerAmplitude
+	if s.level {
+		value = speakerAmplitude
+	}
+	for to >= s.sampleEnd {
+		s.acc += value * (s.sampleEnd - s.pos)
+		s.emit(s.acc / s.cyclesPerSample)
+		s.acc = 0
+		s.pos = s.sampleEnd
+		s.sampleEnd += s.cyclesPerSample
+	}
+	if to > s.pos {
+		s.acc += value * (to - s.pos)
+		s.pos = to
+	}
+}
+
+func (s *Speaker) emit(v float64) {
+	out := v - s.prevIn + speakerDCBlocker*s.prevOut
+	s.prevIn, s.prevOut = v, out
+
+	if limit := s.sampleRate * speakerMaxBufferedSeconds; len(s.samples) >= limit {
+		s.samples = append(s.samples[:0], s.samples[len(s.samples)-limit/2:]...)
+	}
+	s.samples = append(s.samples, float32(out))
+}
+
+// Buffered returns the number of samples available to Pull.
+func (s *Speaker) Buffered() int { return len(s.samples) }
+
+// Pull copies the rendered samples to buf and removes them from the speaker buffer.
+// It returns the number of copied samples.
+func (s *Speaker) Pull(buf []float32) int {
+	n := copy(buf, s.samples)
+	s.samples = append(s.samples[:0], s.samples[n:]...)
+	return n
+}
+
+// WriteWav writes samples produced by the speaker as a WAVE file.
+func (s *Speaker) WriteWav(out io.Writer, samples []float32) error {
+	audio := wav.Audio{SampleRate: s.sampleRate, Samples: make([]float64, len(samples))}
+	for i, v := range samples {
+		audio.Samples[i] = float64(v)
+	}
+	return wav.Write(out, audio)
+}
@@ -0,0 +1,101 @@
+package devices
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"rmazur.io/fahivets/internal/wav"
+)
+
+const (
+	testClock      = 2_000_000
+	testSampleRate = 40_000
+)
+
+func TestSpeakerSquareWave(t *testing.T) {
+	s := NewSpeaker(testClock, testSampleRate)
+
+	// 1 kHz tone for 0.1 second.
+	const halfPeriod = testClock / 1000 / 2
+	for i := range 200 {
+		s.Update(uint64(i*halfPeriod), i%2 == 0)
+	}
+	s.Update(200*halfPeriod, false)
+
+	if got, want := s.Buffered(), testSampleRate/10; got != want {
+		t.Fatalf("got %d samples; want %d", got, want)
+	}
+	samples := make([]float32, s.Buffered())
+	s.Pull(samples)
+	if s.Buffered() != 0 {
+		t.Errorf("got %d samples after pull; want 0", s.Buffered())
+	}
+
+	crossings, peak := 0, float32(0)
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			crossings++
+		}
+		peak = max(peak, samples[i])
+	}
+	if crossings < 198 || crossings > 200 {
+		t.Errorf("got %d zero crossings; want 199", crossings)
+	}
+	if peak < 0.4 || peak > 1 {
+		t.Errorf("got peak %f; want about %f", peak, speakerAmplitude)
+	}
+}
+
+func TestSpeakerShortPulse(t *testing.T) {
+	s := NewSpeaker(testClock, testSampleRate)
+	cyclesPerSample := testClock / testSampleRate
+
+	// A pulse that is a quarter of a sample long must be averaged, not lost or expanded.
+	s.Update(uint64(cyclesPerSample*10), true)
+	s.Update(uint64(cyclesPerSample*10+cyclesPerSample/4), false)
+	s.Update(uint64(cyclesPerSample*12), false)
+
+	samples := make([]float32, 12)
+	if n := s.Pull(samples); n != 12 {
+		t.Fatalf("got %d samples; want 12", n)
+	}
+	if got, want := float64(samples[10]-samples[9]), speakerAmplitude*2/4; math.Abs(got-want) > 0.01 {
+		t.Errorf("got pulse response %f; want %f", got, want)
+	}
+}
+
+func TestSpeakerSilence(t *testing.T) {
+	s := NewSpeaker(testClock, testSampleRate)
+	s.Update(0, true)
+	s.Update(testClock, true)
+
+	samples := make([]float32, s.Buffered())
+	s.Pull(samples)
+	if last := samples[len(samples)-1]; math.Abs(float64(last)) > 0.001 {
+		t.Errorf("got %f for a constant level; want 0", last)
+	}
+}
+
+func TestSpeakerWriteWav(t *testing.T) {
+	s := NewSpeaker(testClock, testSampleRate)
+	samples := []float32{0, 0.25, -0.25}
+
+	var out bytes.Buffer
+	if err := s.WriteWav(&out, samples); err != nil {
+		t.Fatal(err)
+	}
+	audio, err := wav.Read(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audio.SampleRate != testSampleRate || len(audio.Samples) != len(samples) {
+		t.Fatalf("got %d samples at %d Hz; want %d at %d Hz",
+			len(audio.Samples), audio.SampleRate, len(samples), testSampleRate)
+	}
+	for i := range samples {
+		if math.Abs(audio.Samples[i]-float64(samples[i])) > 0.001 {
+			t.Errorf("sample %d: got %f; want %f", i, audio.Samples[i], samples[i])
+		}
+	}
+}
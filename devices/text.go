@@ -0,0 +1,103 @@
+package devices
+
+import "strings"
+
+// FontAddr is the address of the character generator stored in the bootloader ROM. Every character code from 0
+// to 0x7f has 8 bytes, the lower 6 bits of a byte are a glyph row with the leftmost pixel in bit 5.
+const FontAddr = 0xC400
+
+// The monitor draws the characters in a grid of CharWidth x CharHeight cells, the glyph starts from the second
+// line of the cell.
+const (
+	CharWidth   = 6
+	CharHeight  = 10
+	TextColumns = 64
+	TextRows    = DisplayHeight / CharHeight
+)
+
+// UnknownChar marks the cells that do not match any glyph of the font.
+const UnknownChar = '�'
+
+// fontChar returns the character drawn for the code in KOI-7, or 0 if the code has no printable glyph.
+func fontChar(code byte) rune {
+	switch {
+	case code >= 0x20 && code < 0x60:
+		return rune(code)
+	case code >= 0x60 && code < 0x7F:
+		return []rune(koi7Cyrillic)[code-0x60]
+	case code == 0x7F:
+		return '█'
+	}
+	return 0
+}
+
+func isLatinLetter(code byte) bool { return code >= 'A' && code <= 'Z' }
+
+func isCyrillicLetter(code byte) bool { return code >= 0x60 && code < 0x7F }
+
+// Text recognises the characters on the display with the font from the ROM mapped at FontAddr.
+// It returns TextRows lines without the trailing spaces, the cells that do not match any glyph are marked
+// with UnknownChar.
+// Some Latin and Cyrillic letters have the same glyphs: they are read as Cyrillic in the words that have other
+// Cyrillic letters, and as Latin otherwise.
+func (c *Display) Text() []string {
+	glyphs := make(map[[8]byte][]byte)
+	for code := byte(0x20); code < 0x80; code++ {
+		var g [8]byte
+		copy(g[:], c.font[int(code)*8:])
+		glyphs[g] = append(glyphs[g], code)
+	}
+
+	res := make([]string, TextRows)
+	for row := range res {
+		cells := make([][]byte, TextColumns)
+		for col := range cells {
+			cells[col] = glyphs[c.glyphAt(col*CharWidth, row*CharHeight+1)]
+		}
+
+		var line strings.Builder
+		for start := 0; start < len(cells); {
+			end := start + 1
+			if isLetterCell(cells[start]) {
+				for end < len(cells) && isLetterCell(cells[end]) {
+					end++
+				}
+			}
+			cyrillic := false
+			for _, codes := range cells[start:end] {
+				cyrillic = cyrillic || len(codes) > 0 && isCyrillicLetter(codes[0])
+			}
+			for _, codes := range cells[start:end] {
+				switch {
+				case len(codes) == 0:
+					line.WriteRune(UnknownChar)
+				case cyrillic:
+					line.WriteRune(fontChar(codes[len(codes)-1]))
+				default:
+					line.WriteRune(fontChar(codes[0]))
+				}
+			}
+			start = end
+		}
+		res[row] = strings.TrimRight(line.String(), " ")
+	}
+	return res
+}
+
+// isLetterCell reports whether the cell matches a letter glyph.
+func isLetterCell(codes []byte) bool {
+	return len(codes) > 0 && (isLatinLetter(codes[0]) || isCyrillicLetter(codes[0]))
+}
+
+// glyphAt reads the 8 glyph rows of CharWidth pixels at the point.
+func (c *Display) glyphAt(x, y int) [8]byte {
+	var g [8]byte
+	for i := range g {
+		for dx := range CharWidth {
+			px := x + dx
+			b := c.mem[px/8*DisplayHeight+y+i]
+			g[i] = g[i]<<1 | b>>(7-px%8)&1
+		}
+	}
+	return g
+}
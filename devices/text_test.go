@@ -0,0 +1,66 @@
+package devices
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"rmazur.io/fahivets/arch"
+)
+
+// drawText draws the KOI-7 codes with the font from the memory, the way the monitor does.
+func drawText(cpu *arch.CPU, col, row int, codes []byte) {
+	start, _ := arch.MemoryMappingRange(arch.MemDisplay12K)
+	mem := cpu.Memory[start:]
+	for i, code := range codes {
+		x := (col + i) * CharWidth
+		for line := range 8 {
+			g := cpu.Memory[FontAddr+int(code)*8+line]
+			y := row*CharHeight + 1 + line
+			for dx := range CharWidth {
+				px := x + dx
+				bit := byte(0x80) >> (px % 8)
+				if g&(0x20>>dx) != 0 {
+					mem[px/8*DisplayHeight+y] |= bit
+				} else {
+					mem[px/8*DisplayHeight+y] &^= bit
+				}
+			}
+		}
+	}
+}
+
+func TestDisplayText(t *testing.T) {
+	rom, err := os.ReadFile("../testdata/progs/bootloader.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cpu arch.CPU
+	copy(cpu.Memory[arch.MemoryMapping(arch.MemROM2K):], rom)
+	d := NewDisplay(&cpu)
+
+	drawText(&cpu, 0, 0, []byte("HELLO, WORLD!"))
+	// ПРИВЕТ, HE.
+	drawText(&cpu, 10, 1, []byte{0x70, 0x72, 0x69, 0x77, 0x65, 0x74, ',', ' ', 'H', 'E', 0x7F})
+	drawText(&cpu, 63, 24, []byte("9"))
+	// A cell that does not match any glyph.
+	start, _ := arch.MemoryMappingRange(arch.MemDisplay12K)
+	cpu.Memory[start+3*CharHeight+2] = 0x81
+
+	got := d.Text()
+	if len(got) != TextRows {
+		t.Fatalf("got %d rows; want %d", len(got), TextRows)
+	}
+	want := make([]string, TextRows)
+	want[0] = "HELLO, WORLD!"
+	want[1] = "          ПРИВЕТ, HE█"
+	want[3] = string(UnknownChar) + string(UnknownChar)
+	want[24] = "                                                               9"
+	if !slices.Equal(got, want) {
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("got row %d %q; want %q", i, got[i], want[i])
+			}
+		}
+	}
+}
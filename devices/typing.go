@@ -0,0 +1,158 @@
+package devices
+
+import (
+	"fmt"
+	"slices"
+	"unicode"
+)
+
+// KeyRus is the code of the РУС key that switches the monitor to the Cyrillic characters.
+// The НР key switches it back.
+var KeyRus = MatrixKeyCode(0, 11)
+
+// koi7Layout contains the character codes produced by the monitor for the matrix keys, it repeats the table
+// stored in the bootloader at 0xc4a0 (indexed by row and column here).
+// Holding НР, or switching to the Cyrillic mode with РУС, changes the codes from 0x21 to 0x3f with XOR 0x10,
+// and the codes from 0x40 to 0x5f with XOR 0x20 (Cyrillic letters in KOI-7).
+var koi7Layout = [6][12]byte{
+	{0x0d, 0x0a, 0x18, 0x80, 0x08, 0x03, 0x09, 0x20, 0x1a, 0x19, 0x0c, 0x81},
+	{'_', '/', ',', '@', 'B', 'X', 'T', 'I', 'M', 'S', '^', 'Q'},
+	{'.', '\\', 'V', 'D', 'L', 'O', 'R', 'P', 'A', 'W', 'Y', 'F'},
+	{':', 'H', 'Z', ']', '[', 'G', 'N', 'E', 'K', 'U', 'C', 'J'},
+	{'=', '0', '9', '8', '7', '6', '5', '4', '3', '2', '1', ';'},
+	{0x1f, 0x8c, 0x8b, 0x8a, 0x89, 0x88, 0x87, 0x86, 0x85, 0x84, 0x83, 0x82},
+}
+
+// koi7Cyrillic lists the Cyrillic letters in the order of their KOI-7 codes starting from 0x60.
+const koi7Cyrillic = "ЮАБЦДЕФГХИЙКЛМНОПЯРСТУЖВЬЫЗШЭЩЧЪ"
+
+var koi7Keys = func() map[byte]KeyCode {
+	res := make(map[byte]KeyCode)
+	for row := range koi7Layout {
+		for col, code := range koi7Layout[row] {
+			res[code] = MatrixKeyCode(row, col)
+		}
+	}
+	return res
+}()
+
+// Typing configures the timing of Keyboard.Type in CPU cycles.
+type Typing struct {
+	// Hold is the time a key is held down.
+	Hold uint64
+	// Release is the pause after a key is released before the next one is pressed.
+	// The monitor waits until all the keys are released for a while, and beeps on every key.
+	Release uint64
+	// Switch is the pause after the register switch. The monitor applies НР only when it's released
+	// for a while.
+	Switch uint64
+}
+
+// DefaultTyping works with the standard monitor.
+var DefaultTyping = Typing{Hold: 20_000, Release: 150_000, Switch: 200_000}
+
+type kbMode byte
+
+const (
+	kbModeUnknown kbMode = iota
+	kbModeLat
+	kbModeRus
+)
+
+// keyStroke is a key typed as a part of the text.
+type keyStroke struct {
+	code    KeyCode
+	shifted bool // Typed holding НР in the Latin mode.
+	rus     bool // Requires the Cyrillic mode.
+	plain   bool // Requires the Latin mode.
+}
+
+// Type schedules key strokes that enter the text, converting the characters with the KOI-7 layout of the monitor.
+// Latin letters are typed in the upper case, Cyrillic letters are typed in the РУС mode, other characters
+// switch back to the Latin mode with НР, or are typed holding НР. The typing starts after the previously
+// typed text, and it returns the cycle when the last key is released and the pause after it passes.
+func (kb *Keyboard) Type(text string) (done uint64, err error) {
+	var strokes []keyStroke
+	for _, r := range text {
+		s, err := charStroke(r)
+		if err != nil {
+			return 0, err
+		}
+		strokes = append(strokes, s)
+	}
+
+	t, mode := kb.typeEnd, kb.typeMode
+	if now := kb.sched.Now(); t <= now {
+		t, mode = now, kb.mode
+	}
+	at := func(f func()) { kb.sched.At(t, f) }
+	press := func(code KeyCode) {
+		at(func() { kb.Event(code, KeyStateDown) })
+		t += kb.Typing.Hold
+		at(func() { kb.Event(code, KeyStateUp) })
+		t += kb.Typing.Release
+	}
+	toLat := func() {
+		at(func() { kb.Event(KeyShift, KeyStateDown) })
+		t += kb.Typing.Hold
+		at(func() { kb.Event(KeyShift, KeyStateUp) })
+		t += kb.Typing.Switch
+	}
+
+	for i := 0; i < len(strokes); i++ {
+		s := strokes[i]
+		switch {
+		case s.rus && mode != kbModeRus:
+			press(KeyRus)
+			mode = kbModeRus
+		case s.plain && mode != kbModeLat:
+			toLat()
+			mode = kbModeLat
+		case s.shifted && mode != kbModeRus:
+			if mode != kbModeLat {
+				toLat()
+				mode = kbModeLat
+			}
+			// Keep НР down for all the shifted characters in a row. The monitor beeps when it's pressed.
+			at(func() { kb.Event(KeyShift, KeyStateDown) })
+			t += kb.Typing.Release
+			for ; i < len(strokes) && strokes[i].shifted; i++ {
+				press(strokes[i].code)
+			}
+			i--
+			at(func() { kb.Event(KeyShift, KeyStateUp) })
+			t += kb.Typing.Switch
+			continue
+		}
+		press(s.code)
+	}
+	kb.typeMode = mode
+	kb.typeEnd = t
+	return t, nil
+}
+
+func charStroke(r rune) (keyStroke, error) {
+	switch r {
+	case '\n', '\r':
+		return keyStroke{code: koi7Keys[0x0d]}, nil
+	case ' ', '\t':
+		return keyStroke{code: koi7Keys[byte(r)]}, nil
+	}
+	r = unicode.ToUpper(r)
+	if r == 'Ё' {
+		r = 'Е'
+	}
+	if i := slices.Index([]rune(koi7Cyrillic), r); i >= 0 {
+		return keyStroke{code: koi7Keys[byte(0x60+i)^0x20], rus: true}, nil
+	}
+	if r < 0x21 || r > 0x5f {
+		return keyStroke{}, fmt.Errorf("cannot type %q", r)
+	}
+	if code, ok := koi7Keys[byte(r)]; ok {
+		return keyStroke{code: code, plain: true}, nil
+	}
+	if code, ok := koi7Keys[byte(r)^0x10]; ok && r < 0x40 {
+		return keyStroke{code: code, shifted: true}, nil
+	}
+	return keyStroke{}, fmt.Errorf("cannot type %q", r)
+}
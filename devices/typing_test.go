@@ -0,0 +1,109 @@
+package devices
+
+import (
+	"slices"
+	"testing"
+
+	"rmazur.io/fahivets/arch"
+)
+
+func TestCharStroke(t *testing.T) {
+	for _, tc := range []struct {
+		r    rune
+		want keyStroke
+	}{
+		{r: 'a', want: keyStroke{code: MatrixKeyCode(2, 8), plain: true}},
+		{r: 'Q', want: keyStroke{code: MatrixKeyCode(1, 11), plain: true}},
+		{r: '1', want: keyStroke{code: MatrixKeyCode(4, 10), plain: true}},
+		{r: '!', want: keyStroke{code: MatrixKeyCode(4, 10), shifted: true}},
+		{r: '-', want: keyStroke{code: MatrixKeyCode(4, 0), shifted: true}},
+		{r: '?', want: keyStroke{code: MatrixKeyCode(1, 1), shifted: true}},
+		{r: 'ю', want: keyStroke{code: MatrixKeyCode(1, 3), rus: true}},
+		{r: 'Ъ', want: keyStroke{code: MatrixKeyCode(1, 0), rus: true}},
+		{r: 'ё', want: keyStroke{code: MatrixKeyCode(3, 7), rus: true}},
+		{r: ' ', want: keyStroke{code: MatrixKeyCode(0, 7)}},
+		{r: '\n', want: keyStroke{code: MatrixKeyCode(0, 0)}},
+	} {
+		got, err := charStroke(tc.r)
+		if err != nil {
+			t.Errorf("%q: %s", tc.r, err)
+		} else if got != tc.want {
+			t.Errorf("%q: got %+v; want %+v", tc.r, got, tc.want)
+		}
+	}
+	for _, r := range "~`{ї" {
+		if _, err := charStroke(r); err == nil {
+			t.Errorf("%q: expected an error", r)
+		}
+	}
+}
+
+func TestKeyboardType(t *testing.T) {
+	var cpu arch.CPU
+	s := NewScheduler(&cpu.Cycles)
+	kb := NewKeyboard(NewWiring(arch.InitIoController(&cpu), &cpu.Cycles), s)
+	kb.Typing = Typing{Hold: 10, Release: 20, Switch: 30}
+
+	done, err := kb.Type("A!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done != 150 {
+		t.Errorf("got done at %d; want 150", done)
+	}
+	done, err = kb.Type("Я")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done != 210 {
+		t.Errorf("got done at %d for the next text; want 210", done)
+	}
+
+	type change struct {
+		cycle uint64
+		shift KeyState
+		keys  []KeyCode
+	}
+	pressed := func() (res []KeyCode) {
+		for row := range kb.matrix.states {
+			for col, state := range kb.matrix.states[row] {
+				if state == KeyStateDown {
+					res = append(res, MatrixKeyCode(row, col))
+				}
+			}
+		}
+		return
+	}
+	var got []change
+	for cpu.Cycles = 0; cpu.Cycles <= done; cpu.Cycles++ {
+		s.Run()
+		c := change{cpu.Cycles, kb.Shift(), pressed()}
+		if len(got) == 0 || c.shift != got[len(got)-1].shift || !slices.Equal(c.keys, got[len(got)-1].keys) {
+			got = append(got, c)
+		}
+	}
+
+	a, one, ya := MatrixKeyCode(2, 8), MatrixKeyCode(4, 10), MatrixKeyCode(1, 11)
+	want := []change{
+		{0, KeyStateDown, nil}, // The register is unknown, switch to the Latin one.
+		{10, KeyStateUp, nil},
+		{40, KeyStateUp, []KeyCode{a}},
+		{50, KeyStateUp, nil},
+		{70, KeyStateDown, nil},
+		{90, KeyStateDown, []KeyCode{one}},
+		{100, KeyStateDown, nil},
+		{120, KeyStateUp, nil},
+		{150, KeyStateUp, []KeyCode{KeyRus}},
+		{160, KeyStateUp, nil},
+		{180, KeyStateUp, []KeyCode{ya}},
+		{190, KeyStateUp, nil},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i].cycle != want[i].cycle || got[i].shift != want[i].shift || !slices.Equal(got[i].keys, want[i].keys) {
+			t.Errorf("got change %d %+v; want %+v", i, got[i], want[i])
+		}
+	}
+}
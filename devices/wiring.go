@@ -0,0 +1,219 @@
+package devices
+
+import "rmazur.io/fahivets/arch"
+
+// Pins selects a group of pins of an IO controller port.
+type Pins struct {
+	Port arch.Port
+	Mask byte
+}
+
+// Pin selects a single pin of the port.
+func Pin(port arch.Port, bit int) Pins { return Pins{Port: port, Mask: 1 << bit} }
+
+// Port selects all the pins of the port.
+func Port(port arch.Port) Pins { return Pins{Port: port, Mask: 0xFF} }
+
+// Resolution defines how the levels of several drivers connected to the same net are combined.
+type Resolution byte
+
+const (
+	// WiredAnd nets are low if any driver pulls them down. A net without active drivers is pulled up.
+	WiredAnd Resolution = iota
+	// WiredOr nets are high if any driver pulls them up. A net without active drivers is pulled down.
+	WiredOr
+)
+
+// Wiring connects the devices to the pins of the IO controller.
+// Every pin is a net that can have several drivers: the IO controller itself (when the pin is an output),
+// and any number of devices. The level of the net is resolved according to its Resolution, and it's sent
+// back to the IO controller inputs and to the devices that sense the pin.
+// Changes are propagated synchronously, so the wiring must be used from the routine that runs the CPU.
+type Wiring struct {
+	ctl   *arch.IoController
+	clock *uint64
+
+	wiredOr [3]byte // Pins with WiredOr resolution.
+	values  [3]byte // Resolved net levels.
+
+	drivers []*Driver
+	sensors []*sensor
+
+	updating, dirty bool
+	unsettled       uint64
+}
+
+type sensor struct {
+	pins Pins
+	f    arch.OutputListener
+
+	// Sensors of a driver see the levels without its own contribution.
+	owner *Driver
+	last  byte
+}
+
+// NewWiring creates wiring for the IO controller. The clock value is used to timestamp the changes.
+func NewWiring(ctl *arch.IoController, clock *uint64) *Wiring {
+	w := &Wiring{ctl: ctl, clock: clock}
+	for port := range w.values {
+		ctl.Subscribe(arch.Port(port), 0xFF, func(uint64, byte) { w.update() })
+	}
+	w.update()
+	return w
+}
+
+// SetResolution changes the way the levels of the pins are resolved. All the pins are WiredAnd by default.
+func (w *Wiring) SetResolution(pins Pins, r Resolution) {
+	if r == WiredOr {
+		w.wiredOr[pins.Port] |= pins.Mask
+	} else {
+		w.wiredOr[pins.Port] &^= pins.Mask
+	}
+	w.update()
+}
+
+// Driver declares the pins a device drives. Initially the driver is released and does not affect the nets.
+func (w *Wiring) Driver(pins ...Pins) *Driver {
+	d := &Driver{w: w}
+	for _, p := range pins {
+		d.mask[p.Port] |= p.Mask
+	}
+	w.drivers = append(w.drivers, d)
+	return d
+}
+
+// Sense registers a function that is called with the port levels every time any of the selected pins changes.
+// It's called immediately with the current levels.
+func (w *Wiring) Sense(pins Pins, f arch.OutputListener) {
+	w.sensors = append(w.sensors, &sensor{pins: pins, f: f})
+	f(*w.clock, w.values[pins.Port])
+}
+
+// Value returns the current levels of the port pins.
+func (w *Wiring) Value(port arch.Port) byte { return w.values[port] }
+
+func (w *Wiring) resolve(port arch.Port) byte { return w.resolveExcept(port, nil) }
+
+// resolveExcept resolves the levels ignoring the driver.
+func (w *Wiring) resolveExcept(port arch.Port, skip *Driver) byte {
+	and, or := byte(0xFF), byte(0)
+	driven, out := w.ctl.Driven(port), w.ctl.Output(port)
+	and &= out | ^driven
+	or |= out & driven
+	for _, d := range w.drivers {
+		if d == skip {
+			continue
+		}
+		active := d.mask[port] & d.active[port]
+		and &= d.levels[port] | ^active
+		or |= d.levels[port] & active
+	}
+	wiredOr := w.wiredOr[port]
+	return and&^wiredOr | or&wiredOr
+}
+
+// maxUpdatePasses is the number of the port pins. A pass resolves the nets and notifies the sensors, and
+// the levels the sensors drive in response are resolved in the next pass. Without a feedback loop, a change
+// travels along a chain of devices each triggered by a different pin, so the chain can't be longer than the
+// number of pins. A change still propagating after that many passes goes round a loop that oscillates.
+const maxUpdatePasses = 3 * 8
+
+// Unsettled returns the number of updates stopped by the pass limit with the levels still changing.
+// It's not zero only if the devices form an oscillating loop.
+func (w *Wiring) Unsettled() uint64 { return w.unsettled }
+
+func (w *Wiring) update() {
+	if w.updating {
+		// Sensors changed the drivers, propagate it in the next round.
+		w.dirty = true
+		return
+	}
+	w.updating = true
+	defer func() { w.updating = false }()
+
+	// Drivers that keep flipping each other, like a ring of inverters, would never settle, so the levels of
+	// the last pass are accepted and counted as unsettled.
+	for pass := 0; pass == 0 || w.dirty && pass < maxUpdatePasses; pass++ {
+		w.dirty = false
+		for port := range w.values {
+			prev, v := w.values[port], w.resolve(arch.Port(port))
+			if prev == v {
+				continue
+			}
+			w.values[port] = v
+			switch arch.Port(port) {
+			case arch.PortA:
+				w.ctl.SendA(v)
+			case arch.PortB:
+				w.ctl.SendB(v)
+			case arch.PortC:
+				w.ctl.SendCLow(v)
+				w.ctl.SendCHigh(v >> 4)
+			}
+			for _, s := range w.sensors {
+				if s.owner == nil && s.pins.Port == arch.Port(port) && (prev^v)&s.pins.Mask != 0 {
+					s.f(*w.clock, v)
+				}
+			}
+		}
+		for _, s := range w.sensors {
+			if s.owner == nil {
+				continue
+			}
+			if v := w.resolveExcept(s.pins.Port, s.owner); (s.last^v)&s.pins.Mask != 0 {
+				s.last = v
+				s.f(*w.clock, v)
+			}
+		}
+	}
+	if w.dirty {
+		w.unsettled++
+		w.dirty = false
+	}
+}
+
+// Driver is a set of pins a device drives.
+type Driver struct {
+	w      *Wiring
+	mask   [3]byte
+	active [3]byte
+	levels [3]byte
+}
+
+// Drive sets the levels of the selected pins. Pins not declared by the driver are ignored.
+func (d *Driver) Drive(pins Pins, value byte) {
+	m := pins.Mask & d.mask[pins.Port]
+	active, levels := d.active[pins.Port]|m, d.levels[pins.Port]&^m|value&m
+	if active == d.active[pins.Port] && levels == d.levels[pins.Port] {
+		return
+	}
+	d.active[pins.Port], d.levels[pins.Port] = active, levels
+	d.w.update()
+}
+
+// Others returns the levels of the port pins as they would be without this driver.
+// Devices use it to react on the levels driven by the others, without seeing their own output.
+func (d *Driver) Others(port arch.Port) byte { return d.w.resolveExcept(port, d) }
+
+// Sense registers a function that is called every time any of the selected pins changes because of the other drivers.
+// The function gets the same levels as Others returns, and it's called immediately with the current levels.
+func (d *Driver) Sense(pins Pins, f arch.OutputListener) {
+	s := &sensor{pins: pins, f: f, owner: d, last: d.Others(pins.Port)}
+	d.w.sensors = append(d.w.sensors, s)
+	f(*d.w.clock, s.last)
+}
+
+// Set sets the level of the selected pins.
+func (d *Driver) Set(pins Pins, high bool) {
+	if high {
+		d.Drive(pins, 0xFF)
+	} else {
+		d.Drive(pins, 0)
+	}
+}
+
+// Release stops driving the selected pins.
+func (d *Driver) Release(pins Pins) {
+	d.active[pins.Port] &^= pins.Mask
+	d.w.update()
+}
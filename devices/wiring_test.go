@@ -0,0 +1,107 @@
+package devices
+
+import (
+	"testing"
+
+	"rmazur.io/fahivets/arch"
+)
+
+func TestWiring(t *testing.T) {
+	var cpu arch.CPU
+	ioc := arch.InitIoController(&cpu)
+	w := NewWiring(ioc, &cpu.Cycles)
+
+	// All ports are inputs after reset.
+	for _, port := range []arch.Port{arch.PortA, arch.PortB, arch.PortC} {
+		if v := w.Value(port); v != 0xFF {
+			t.Errorf("got 0x%02x on port %d after reset; want 0xff", v, port)
+		}
+	}
+
+	d1 := w.Driver(Port(arch.PortB))
+	d2 := w.Driver(Pin(arch.PortB, 0), Pin(arch.PortB, 1))
+
+	t.Run("wired and", func(t *testing.T) {
+		d1.Drive(Port(arch.PortB), 0xFE)
+		d2.Drive(Port(arch.PortB), 0xFD) // Only pins 0 and 1 are driven.
+		if v := cpu.Read(arch.MemoryIoCtrl + 1); v != 0xFC {
+			t.Errorf("got 0x%02x; want 0xfc", v)
+		}
+		d2.Release(Pin(arch.PortB, 1))
+		if v := w.Value(arch.PortB); v != 0xFE {
+			t.Errorf("got 0x%02x after release; want 0xfe", v)
+		}
+		d1.Release(Port(arch.PortB))
+		d2.Release(Port(arch.PortB))
+	})
+
+	t.Run("wired or", func(t *testing.T) {
+		w.SetResolution(Pin(arch.PortB, 0), WiredOr)
+		if v := w.Value(arch.PortB); v != 0xFE {
+			t.Errorf("got 0x%02x without drivers; want 0xfe", v)
+		}
+		d1.Set(Pin(arch.PortB, 0), false)
+		d2.Set(Pin(arch.PortB, 0), true)
+		if v := w.Value(arch.PortB); v != 0xFF {
+			t.Errorf("got 0x%02x; want 0xff", v)
+		}
+		d2.Set(Pin(arch.PortB, 0), false)
+		if v := w.Value(arch.PortB); v != 0xFE {
+			t.Errorf("got 0x%02x; want 0xfe", v)
+		}
+	})
+
+	t.Run("controller outputs", func(t *testing.T) {
+		type change struct {
+			cycle uint64
+			value byte
+		}
+		var changes []change
+		w.Sense(Pin(arch.PortC, 5), func(cycle uint64, v byte) { changes = append(changes, change{cycle, v}) })
+
+		// A device reacting to the controller output with another pin.
+		echo := w.Driver(Pin(arch.PortC, 0))
+		w.Sense(Pin(arch.PortC, 5), func(_ uint64, v byte) { echo.Set(Pin(arch.PortC, 0), v&0x20 != 0) })
+
+		cpu.Cycles = 10
+		cpu.Write(arch.MemoryIoCtrl+3, 0x81) // Upper C is an output.
+		cpu.Cycles = 20
+		cpu.Write(arch.MemoryIoCtrl+3, 0x0B) // Set PC5.
+		if v := cpu.Read(arch.MemoryIoCtrl + 2); v != 0x2F {
+			t.Errorf("got 0x%02x reading port C; want 0x2f", v)
+		}
+
+		want := []change{{0, 0xFF}, {10, 0x0F}, {20, 0x2E}}
+		if len(changes) != len(want) {
+			t.Fatalf("got changes %v; want %v", changes, want)
+		}
+		for i := range want {
+			if changes[i] != want[i] {
+				t.Errorf("got change %d %+v; want %+v", i, changes[i], want[i])
+			}
+		}
+	})
+}
+
+func TestWiringOscillation(t *testing.T) {
+	var cpu arch.CPU
+	w := NewWiring(arch.InitIoController(&cpu), &cpu.Cycles)
+
+	// Two devices driving each other make an inverter ring that never settles.
+	a := w.Driver(Pin(arch.PortA, 0))
+	b := w.Driver(Pin(arch.PortB, 0))
+	a.Sense(Pin(arch.PortB, 0), func(_ uint64, v byte) { a.Set(Pin(arch.PortA, 0), v&1 != 0) })
+	b.Sense(Pin(arch.PortA, 0), func(_ uint64, v byte) { b.Set(Pin(arch.PortB, 0), v&1 == 0) })
+	calls := 0
+	w.Sense(Pin(arch.PortB, 0), func(uint64, byte) { calls++ })
+
+	// The update returns after the limited number of passes.
+	unsettled := w.Unsettled()
+	a.Set(Pin(arch.PortA, 0), false)
+	if calls == 0 {
+		t.Error("the oscillation is not propagated")
+	}
+	if n := w.Unsettled() - unsettled; n != 1 {
+		t.Errorf("got %d unsettled updates; want 1", n)
+	}
+}
@@ -0,0 +1,138 @@
+package fahivets
+
+import (
+	"image"
+	"slices"
+	"sync"
+
+	"rmazur.io/fahivets/devices"
+)
+
+// Frame is the display image published at the end of an emulated frame. The frames are shared by the readers
+// and their images are reused once all the readers release them, so a frame must not be modified, and must not
+// be used after Release.
+type Frame struct {
+	// Seq is the sequence number of the frame starting from 1. A gap in the sequence means the reader missed
+	// some frames.
+	Seq uint64
+	// Cycle is the CPU cycle the frame was published at.
+	Cycle uint64
+	// Image is the display image at scale 1.
+	Image *image.RGBA
+	// Dirty are the regions of the image changed since the frame Seq-1.
+	Dirty []image.Rectangle
+
+	store *frameStore
+	// refs is the number of the frame holders, including the store while the frame is the latest one.
+	refs int
+}
+
+// Release returns the frame to the store.
+func (f *Frame) Release() {
+	f.store.mu.Lock()
+	defer f.store.mu.Unlock()
+	f.store.unref(f)
+}
+
+// frameStore passes the frames from the emulation goroutine to the readers. The published frame is never
+// written again: the next one is rendered into a released buffer, or into a new one if the readers hold all
+// of them. With the readers that keep up it's a triple buffer: the frame being written, the latest one, and
+// the one being read.
+type frameStore struct {
+	// renderer is used only by the publishing goroutine.
+	renderer *devices.DisplayRenderer
+
+	mu     sync.Mutex
+	seq    uint64
+	latest *Frame
+	free   []*Frame
+	subs   []chan *Frame
+}
+
+func (s *frameStore) unref(f *Frame) {
+	if f.refs--; f.refs == 0 {
+		s.free = append(s.free, f)
+	}
+}
+
+// PublishFrame renders the display and publishes it as the latest frame. It must be called from the goroutine
+// running the machine. Run publishes a frame after every frame of the emulated time.
+func (c *Computer) PublishFrame() {
+	s := &c.frames
+	if s.renderer == nil {
+		s.renderer = c.Display.NewRenderer(1)
+	}
+	img, dirty := s.renderer.Render()
+
+	s.mu.Lock()
+	var f *Frame
+	if n := len(s.free); n > 0 {
+		f, s.free = s.free[n-1], s.free[:n-1]
+	} else {
+		f = &Frame{store: s, Image: image.NewRGBA(img.Bounds())}
+	}
+	s.mu.Unlock()
+
+	// Nobody else holds the free frame.
+	copy(f.Image.Pix, img.Pix)
+	f.Dirty = append(f.Dirty[:0], dirty...)
+	f.Cycle = c.CPU.Cycles
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	f.Seq = s.seq
+	f.refs = 1
+	if s.latest != nil {
+		s.unref(s.latest)
+	}
+	s.latest = f
+	for _, ch := range s.subs {
+		// Replace the frame the subscriber has not received yet.
+		select {
+		case old := <-ch:
+			s.unref(old)
+		default:
+		}
+		f.refs++
+		ch <- f
+	}
+}
+
+// LatestFrame returns the last published frame, or nil if there is none yet. The frame must be released.
+// It is safe to call from any goroutine.
+func (c *Computer) LatestFrame() *Frame {
+	s := &c.frames
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latest != nil {
+		s.latest.refs++
+	}
+	return s.latest
+}
+
+// Frames subscribes to the published frames. Every received frame must be released. A slow reader gets only
+// the latest frame, the ones it had no time to receive are dropped. Calling stop closes the channel.
+// It is safe to call from any goroutine.
+func (c *Computer) Frames() (frames <-chan *Frame, stop func()) {
+	s := &c.frames
+	ch := make(chan *Frame, 1)
+	s.mu.Lock()
+	s.subs = append(s.subs, ch)
+	s.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.subs = slices.DeleteFunc(s.subs, func(sub chan *Frame) bool { return sub == ch })
+			select {
+			case old := <-ch:
+				s.unref(old)
+			default:
+			}
+			close(ch)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package fahivets_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"rmazur.io/fahivets"
+	"rmazur.io/fahivets/arch"
+)
+
+// checkFrame verifies that the frame shows the display filled by the frame callback of the previous frame.
+func checkFrame(t *testing.T, f *fahivets.Frame) {
+	t.Helper()
+	want := byte(f.Seq - 1)
+	img := f.Image
+	for y := range img.Rect.Dy() {
+		line := img.Pix[y*img.Stride : y*img.Stride+img.Rect.Dx()*4]
+		for x := 0; x < len(line); x += 4 {
+			lit := want&(0x80>>(x/4%8)) != 0
+			if (line[x] == 0xFF) != lit {
+				t.Errorf("frame %d: got a torn pixel at (%d, %d)", f.Seq, x/4, y)
+				return
+			}
+		}
+	}
+}
+
+func TestFrames(t *testing.T) {
+	m := loopComputer()
+	m.SetSpeed(fahivets.SpeedUnlimited)
+	if f := m.LatestFrame(); f != nil {
+		t.Errorf("got frame %d before the machine runs", f.Seq)
+	}
+
+	const frames = 60
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	displayStart, displayEnd := arch.MemoryMappingRange(arch.MemDisplay12K)
+	display := m.CPU.Memory[displayStart : displayEnd+1]
+	n := 0
+	onFrame := func() {
+		// Fill the display with the pattern of the next frame.
+		n++
+		copy(display, bytes.Repeat([]byte{byte(n)}, len(display)))
+		if n == frames {
+			cancel()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range 2 {
+		sub, stop := m.Frames()
+		wg.Go(func() {
+			defer stop()
+			var last uint64
+			for f := range sub {
+				if f.Seq <= last {
+					t.Errorf("got frame %d after %d", f.Seq, last)
+				}
+				last = f.Seq
+				checkFrame(t, f)
+				f.Release()
+				if last == frames {
+					return
+				}
+			}
+		})
+	}
+	wg.Go(func() {
+		for ctx.Err() == nil {
+			if f := m.LatestFrame(); f != nil {
+				checkFrame(t, f)
+				f.Release()
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+	if err := m.Run(ctx, fahivets.RunOptions{OnFrame: onFrame}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v; want context.Canceled", err)
+	}
+	wg.Wait()
+
+	f := m.LatestFrame()
+	if f == nil || f.Seq != frames {
+		t.Fatalf("got the latest frame %v; want frame %d", f, frames)
+	}
+	if want := uint64(frames * (fahivets.ClockFrequency / 60)); f.Cycle < want || f.Cycle > want+10 {
+		t.Errorf("got frame cycle %d; want %d", f.Cycle, want)
+	}
+	f.Release()
+}
+
+func TestFramesDirty(t *testing.T) {
+	m := loopComputer()
+	sub, stop := m.Frames()
+	defer stop()
+
+	m.PublishFrame()
+	f := <-sub
+	if len(f.Dirty) != 1 || f.Dirty[0] != f.Image.Bounds() {
+		t.Errorf("got first frame dirty regions %v; want the whole image", f.Dirty)
+	}
+	f.Release()
+
+	displayStart, _ := arch.MemoryMappingRange(arch.MemDisplay12K)
+	m.CPU.Memory[displayStart+3] = 0xFF
+	m.PublishFrame()
+	// The slow reader gets the latest frame only.
+	m.PublishFrame()
+	f = <-sub
+	if f.Seq != 3 || len(f.Dirty) != 0 {
+		t.Errorf("got frame %d with dirty regions %v; want frame 3 without changes", f.Seq, f.Dirty)
+	}
+	f.Release()
+
+	stop()
+	if _, ok := <-sub; ok {
+		t.Error("the channel is not closed after stop")
+	}
+}
@@ -2,4 +2,6 @@ module rmazur.io/fahivets
 
 go 1.25
 
-require golang.org/x/image v0.33.0
+require golang.org/x/term v0.37.0
+
+require golang.org/x/sys v0.38.0 // indirect
@@ -0,0 +1,136 @@
+package fahivets
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// HexSegment is a continuous block of bytes of an Intel HEX program.
+type HexSegment struct {
+	Address uint16
+	Content []byte
+}
+
+// HexData is a program read from an Intel HEX file.
+type HexData struct {
+	// Segments are sorted by address and do not overlap, adjacent records are merged.
+	Segments []HexSegment
+	// Start is the address from the start address record, or the lowest address of the data.
+	Start uint16
+}
+
+// Load copies the segments to the memory, the bytes between the segments are left intact.
+func (d HexData) Load(memory []byte) {
+	for _, s := range d.Segments {
+		copy(memory[s.Address:], s.Content)
+	}
+}
+
+// AsHex returns the RKS program as a single segment that starts at its first byte.
+func (d RksData) AsHex() HexData {
+	return HexData{Segments: []HexSegment{{Address: d.StartAddress, Content: d.Content}}, Start: d.StartAddress}
+}
+
+// ReadHex reads a program in the Intel HEX format. The start segment (03) and the start linear (05) address
+// records set the start address, which must fit the 16-bit address space.
+func ReadHex(in io.Reader) (data HexData, err error) {
+	var (
+		content  [0x10000]byte
+		covered  [0x10000]bool
+		hasData  bool
+		hasStart bool
+		scanner  = bufio.NewScanner(in)
+		line     int
+		finished bool
+	)
+	for scanner.Scan() && !finished {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if text[0] != ':' {
+			err = fmt.Errorf("line %d: record must start with ':'", line)
+			return
+		}
+		raw, decErr := hex.DecodeString(text[1:])
+		if decErr != nil {
+			err = fmt.Errorf("line %d: %w", line, decErr)
+			return
+		}
+		if len(raw) < 5 || len(raw) != int(raw[0])+5 {
+			err = fmt.Errorf("line %d: bad record length", line)
+			return
+		}
+		var sum byte
+		for _, b := range raw {
+			sum += b
+		}
+		if sum != 0 {
+			err = fmt.Errorf("line %d: bad checksum", line)
+			return
+		}
+
+		addr, payload := uint16(raw[1])<<8|uint16(raw[2]), raw[4:len(raw)-1]
+		switch raw[3] {
+		case 0x00:
+			if int(addr)+len(payload) > len(content) {
+				err = fmt.Errorf("line %d: data out of the address space", line)
+				return
+			}
+			copy(content[addr:], payload)
+			for i := range payload {
+				covered[int(addr)+i] = true
+			}
+			hasData = true
+		case 0x01:
+			finished = true
+		case 0x03, 0x05:
+			if len(payload) != 4 {
+				err = fmt.Errorf("line %d: bad start address length", line)
+				return
+			}
+			v := binary.BigEndian.Uint32(payload)
+			if raw[3] == 0x03 {
+				// CS:IP.
+				v = v>>16<<4 + v&0xFFFF
+			}
+			if v > 0xFFFF {
+				err = fmt.Errorf("line %d: start address %x out of the address space", line, v)
+				return
+			}
+			data.Start, hasStart = uint16(v), true
+		default:
+			err = fmt.Errorf("line %d: unsupported record type %02x", line, raw[3])
+			return
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	if !hasData {
+		err = fmt.Errorf("no data records")
+		return
+	}
+
+	for a := 0; a < len(content); a++ {
+		if !covered[a] {
+			continue
+		}
+		end := a
+		for end < len(content) && covered[end] {
+			end++
+		}
+		data.Segments = append(data.Segments, HexSegment{Address: uint16(a), Content: slices.Clone(content[a:end])})
+		a = end
+	}
+	if !hasStart {
+		data.Start = data.Segments[0].Address
+	}
+	return
+}
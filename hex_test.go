@@ -0,0 +1,73 @@
+package fahivets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadHex(t *testing.T) {
+	const program = `:03000000C3000139
+:02000400AABB95
+:00000001FF
+:01000000FF00
+`
+	data, err := ReadHex(strings.NewReader(program))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The record after the end of file is ignored.
+	want := []HexSegment{
+		{Address: 0, Content: []byte{0xC3, 0x00, 0x01}},
+		{Address: 4, Content: []byte{0xAA, 0xBB}},
+	}
+	if len(data.Segments) != len(want) {
+		t.Fatalf("got segments %x; want %x", data.Segments, want)
+	}
+	for i, s := range data.Segments {
+		if s.Address != want[i].Address || !bytes.Equal(s.Content, want[i].Content) {
+			t.Errorf("got segment %04x %x; want %04x %x", s.Address, s.Content, want[i].Address, want[i].Content)
+		}
+	}
+	if data.Start != 0 {
+		t.Errorf("got start %04x; want the lowest address 0000", data.Start)
+	}
+
+	// The gap between the segments is not overwritten.
+	memory := bytes.Repeat([]byte{0x55}, 8)
+	data.Load(memory)
+	if want := []byte{0xC3, 0x00, 0x01, 0x55, 0xAA, 0xBB, 0x55, 0x55}; !bytes.Equal(memory, want) {
+		t.Errorf("got memory %x; want %x", memory, want)
+	}
+
+	for _, tc := range []struct {
+		record string
+		start  uint16
+	}{
+		{record: ":040000050000E00017", start: 0xE000},
+		// 0E00:0100.
+		{record: ":040000030E000100EA", start: 0xE100},
+	} {
+		data, err := ReadHex(strings.NewReader(":01E00000C35C\n" + tc.record + "\n:00000001FF\n"))
+		if err != nil {
+			t.Errorf("%s: %s", tc.record, err)
+			continue
+		}
+		if data.Start != tc.start {
+			t.Errorf("%s: got start %04x; want %04x", tc.record, data.Start, tc.start)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"03000000C30001F9",
+		":03000000C3000138",
+		":0300000C300139",
+		":00000002FE",
+		":01E00000C35C\n:0400000500010000F6",
+	} {
+		if _, err := ReadHex(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
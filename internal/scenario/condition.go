@@ -0,0 +1,101 @@
+package scenario
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"rmazur.io/fahivets"
+)
+
+// Condition is a check of the machine state: pc=ADDR, sp=ADDR, a=BYTE (any register), mem[ADDR]=BYTE,
+// or text=TEXT that is met when the screen contains the text.
+type Condition struct {
+	text   string
+	screen bool
+	met    func(m *fahivets.Computer) bool
+}
+
+func (c Condition) String() string { return c.text }
+
+// Met reports whether the condition is met.
+func (c Condition) Met(m *fahivets.Computer) bool { return c.met(m) }
+
+// Screen reports whether the condition reads the screen text. Recognising the text is slow, so such conditions
+// should be checked once per frame rather than after every instruction.
+func (c Condition) Screen() bool { return c.screen }
+
+// ParseCondition parses the condition in the form name=value.
+func ParseCondition(s string) (c Condition, err error) {
+	c.text = s
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return c, fmt.Errorf("condition %q: expected name=value", s)
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "text" {
+		c.screen = true
+		c.met = func(m *fahivets.Computer) bool {
+			return strings.Contains(strings.Join(m.Display.Text(), "\n"), value)
+		}
+		return c, nil
+	}
+
+	// The addresses are 16-bit, the registers and the memory cells are 8-bit.
+	parse := func(bits int) (uint64, error) {
+		v, err := strconv.ParseUint(strings.TrimSpace(value), 0, bits)
+		if err != nil {
+			return 0, fmt.Errorf("condition %q: %w", s, err)
+		}
+		return v, nil
+	}
+	if name == "pc" || name == "sp" {
+		v, err := parse(16)
+		if err != nil {
+			return c, err
+		}
+		want := uint16(v)
+		if name == "pc" {
+			c.met = func(m *fahivets.Computer) bool { return m.CPU.PC == want }
+		} else {
+			c.met = func(m *fahivets.Computer) bool { return m.CPU.SP == want }
+		}
+		return c, nil
+	}
+
+	var get func(m *fahivets.Computer) byte
+	switch name {
+	case "a":
+		get = func(m *fahivets.Computer) byte { return m.CPU.Registers.A }
+	case "b":
+		get = func(m *fahivets.Computer) byte { return m.CPU.Registers.B }
+	case "c":
+		get = func(m *fahivets.Computer) byte { return m.CPU.Registers.C }
+	case "d":
+		get = func(m *fahivets.Computer) byte { return m.CPU.Registers.D }
+	case "e":
+		get = func(m *fahivets.Computer) byte { return m.CPU.Registers.E }
+	case "h":
+		get = func(m *fahivets.Computer) byte { return m.CPU.Registers.H }
+	case "l":
+		get = func(m *fahivets.Computer) byte { return m.CPU.Registers.L }
+	default:
+		addrText, ok := strings.CutPrefix(name, "mem[")
+		addrText, closed := strings.CutSuffix(addrText, "]")
+		if !ok || !closed {
+			return c, fmt.Errorf("condition %q: unknown value %q", s, name)
+		}
+		addr, err := strconv.ParseUint(addrText, 0, 16)
+		if err != nil {
+			return c, fmt.Errorf("condition %q: %w", s, err)
+		}
+		get = func(m *fahivets.Computer) byte { return m.CPU.Memory[addr] }
+	}
+	v, err := parse(8)
+	if err != nil {
+		return c, err
+	}
+	want := byte(v)
+	c.met = func(m *fahivets.Computer) bool { return get(m) == want }
+	return c, nil
+}
@@ -0,0 +1,389 @@
+// Package scenario runs scripted sessions of the guest programs, like
+//
+//	# The monitor echoes the typed command.
+//	boot
+//	wait text="* МОНИТОР ?" 1s
+//	run 500ms
+//	type D
+//	wait text="* МОНИТОР ? D" 100ms
+//	snapshot monitor.png
+//
+// Every line is a step:
+//
+//	boot [PROFILE]            start the machine with the bootloader and the monitor
+//	load FILE [ADDR]          load the .rks or .hex program and jump to ADDR or its start address
+//	run DURATION              run the machine
+//	type TEXT                 type the text and wait until it's typed
+//	press KEY [DURATION]      press the key, hold it for the duration (the typing hold time by default), release it
+//	wait CONDITION [TIMEOUT]  run until the condition is met, fail after the timeout (10s by default)
+//	assert CONDITION          fail if the condition is not met
+//	snapshot FILE             write the display image to the PNG file
+//
+// Durations are numbers of cycles, or milliseconds of the emulated time with the "ms" suffix, or seconds with
+// the "s" suffix. Conditions are described in ParseCondition. Keys are keymap targets ("row,col", "НР", "РУС",
+// "НР+row,col"), or the key codes of the positional keymap ("Enter", "KeyA", "ArrowUp").
+// Arguments can be quoted with the Go escapes, the quotes can start in the middle of an argument (text="A B").
+// Empty lines and lines starting with # are ignored.
+package scenario
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"rmazur.io/fahivets"
+	"rmazur.io/fahivets/arch"
+	"rmazur.io/fahivets/devices"
+)
+
+// DefaultTimeout is the wait step timeout in cycles.
+const DefaultTimeout = 10 * fahivets.ClockFrequency
+
+// frameCycles is the period of checking the screen conditions.
+const frameCycles = fahivets.ClockFrequency / 60
+
+// Scenario is a parsed scenario file.
+type Scenario struct {
+	Name  string
+	steps []step
+}
+
+type step struct {
+	line int
+	name string
+	args []string
+}
+
+// Env provides the files for the scenario.
+type Env struct {
+	// Bootloader and Monitor are the ROM images used by the boot step.
+	Bootloader, Monitor []byte
+	// Dir is the directory the program files are loaded from.
+	Dir string
+	// Output is the directory the snapshots are written to.
+	Output string
+	// Logf logs the steps if set.
+	Logf func(format string, args ...any)
+}
+
+// stepArgs defines the minimal and the maximal number of the step arguments.
+var stepArgs = map[string][2]int{
+	"boot":     {0, 1},
+	"load":     {1, 2},
+	"run":      {1, 1},
+	"type":     {1, 1},
+	"press":    {1, 2},
+	"wait":     {1, 2},
+	"assert":   {1, 1},
+	"snapshot": {1, 1},
+}
+
+// ReadFile parses the scenario file. The scenario is named after the file.
+func ReadFile(path string) (*Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), f)
+}
+
+// Read parses the scenario.
+func Read(name string, r io.Reader) (*Scenario, error) {
+	s := &Scenario{Name: name}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields, err := splitFields(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		st := step{line: line, name: fields[0], args: fields[1:]}
+		n, ok := stepArgs[st.name]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: unknown step %q", name, line, st.name)
+		}
+		if len(st.args) < n[0] || len(st.args) > n[1] {
+			return nil, fmt.Errorf("%s:%d: %s: got %d arguments; want %d to %d", name, line, st.name, len(st.args), n[0], n[1])
+		}
+		if err := st.check(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", name, line, st.name, err)
+		}
+		s.steps = append(s.steps, st)
+	}
+	return s, scanner.Err()
+}
+
+// splitFields splits the line by spaces, unquoting the quoted parts.
+func splitFields(line string) ([]string, error) {
+	var (
+		res    []string
+		field  strings.Builder
+		inWord bool
+	)
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t':
+			if inWord {
+				res = append(res, field.String())
+				field.Reset()
+				inWord = false
+			}
+			i++
+		case c == '"':
+			quoted, err := strconv.QuotedPrefix(line[i:])
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted text %s", line[i:])
+			}
+			text, _ := strconv.Unquote(quoted)
+			field.WriteString(text)
+			inWord = true
+			i += len(quoted)
+		default:
+			field.WriteByte(c)
+			inWord = true
+			i++
+		}
+	}
+	if inWord {
+		res = append(res, field.String())
+	}
+	return res, nil
+}
+
+// check validates the step arguments that do not depend on the environment.
+func (st step) check() error {
+	var err error
+	switch st.name {
+	case "run":
+		_, err = parseDuration(st.args[0])
+	case "press":
+		if _, err = parseKey(st.args[0]); err == nil && len(st.args) > 1 {
+			_, err = parseDuration(st.args[1])
+		}
+	case "wait":
+		if _, err = ParseCondition(st.args[0]); err == nil && len(st.args) > 1 {
+			_, err = parseDuration(st.args[1])
+		}
+	case "assert":
+		_, err = ParseCondition(st.args[0])
+	case "load":
+		if len(st.args) > 1 {
+			_, err = strconv.ParseUint(st.args[1], 0, 16)
+		}
+	case "boot":
+		if len(st.args) > 0 {
+			_, err = fahivets.LookupProfile(st.args[0])
+		}
+	}
+	return err
+}
+
+func parseDuration(s string) (uint64, error) {
+	mul := uint64(1)
+	switch {
+	case strings.HasSuffix(s, "ms"):
+		s, mul = strings.TrimSuffix(s, "ms"), fahivets.ClockFrequency/1000
+	case strings.HasSuffix(s, "s"):
+		s, mul = strings.TrimSuffix(s, "s"), fahivets.ClockFrequency
+	}
+	v, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad duration %q", s)
+	}
+	return v * mul, nil
+}
+
+func parseKey(s string) (devices.KeyBinding, error) {
+	if b, err := devices.ParseKeyBinding(s); err == nil {
+		return b, nil
+	}
+	km, err := devices.BuiltinKeymap(devices.DefaultKeymap)
+	if err != nil {
+		return devices.KeyBinding{}, err
+	}
+	if b, ok := km.Lookup(s, ""); ok {
+		return b, nil
+	}
+	return devices.KeyBinding{}, fmt.Errorf("unknown key %q", s)
+}
+
+// PressKey presses the key now and releases it after the hold cycles. НР is held together with the key if
+// the binding requires it.
+func PressKey(m *fahivets.Computer, b devices.KeyBinding, hold uint64) {
+	kb := m.Keyboard
+	shift := b.Shift || b.Key == devices.KeyShift
+	if shift {
+		kb.Event(devices.KeyShift, devices.KeyStateDown)
+	}
+	if b.Key != devices.KeyShift {
+		kb.Event(b.Key, devices.KeyStateDown)
+	}
+	m.Scheduler.After(hold, func() {
+		if b.Key != devices.KeyShift {
+			kb.Event(b.Key, devices.KeyStateUp)
+		}
+		if shift {
+			kb.Event(devices.KeyShift, devices.KeyStateUp)
+		}
+	})
+}
+
+// Run executes the scenario steps. It returns the error of the first failed step.
+func (s *Scenario) Run(env Env) error {
+	r := runner{env: env}
+	for _, st := range s.steps {
+		if env.Logf != nil {
+			env.Logf("%s:%d: %s %s", s.Name, st.line, st.name, strings.Join(st.args, " "))
+		}
+		if st.name != "boot" && r.m == nil {
+			return fmt.Errorf("%s:%d: %s: the machine is not booted", s.Name, st.line, st.name)
+		}
+		if err := r.step(st); err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", s.Name, st.line, st.name, err)
+		}
+	}
+	return nil
+}
+
+type runner struct {
+	env Env
+	m   *fahivets.Computer
+}
+
+func (r *runner) step(st step) error {
+	switch st.name {
+	case "boot":
+		return r.boot(st.args)
+	case "load":
+		return r.load(st.args)
+	case "run":
+		d, _ := parseDuration(st.args[0])
+		return r.m.RunFor(d)
+	case "type":
+		done, err := r.m.Keyboard.Type(st.args[0])
+		if err != nil {
+			return err
+		}
+		return r.m.RunUntil(done)
+	case "press":
+		b, _ := parseKey(st.args[0])
+		hold := r.m.Keyboard.Typing.Hold
+		if len(st.args) > 1 {
+			hold, _ = parseDuration(st.args[1])
+		}
+		PressKey(r.m, b, hold)
+		return r.m.RunFor(hold)
+	case "wait":
+		c, _ := ParseCondition(st.args[0])
+		timeout := uint64(DefaultTimeout)
+		if len(st.args) > 1 {
+			timeout, _ = parseDuration(st.args[1])
+		}
+		return r.wait(c, timeout)
+	case "assert":
+		c, _ := ParseCondition(st.args[0])
+		if !c.Met(r.m) {
+			return fmt.Errorf("%s is not met%s", c, r.state(c))
+		}
+	case "snapshot":
+		return r.snapshot(st.args[0])
+	}
+	return nil
+}
+
+func (r *runner) boot(args []string) error {
+	profile := fahivets.ProfileStandard
+	if len(args) > 0 {
+		profile, _ = fahivets.LookupProfile(args[0])
+	}
+	m := fahivets.NewComputerProfile(profile)
+	romStart := arch.MemoryMapping(arch.MemROM2K)
+	copy(m.CPU.Memory[romStart:], r.env.Bootloader)
+	copy(m.CPU.Memory[arch.MemoryMapping(arch.MemROMExtra12K):], r.env.Monitor)
+	m.CPU.PC = uint16(romStart)
+	// Make sure bootloader is executed.
+	for range 16_000 {
+		if _, _, err := m.Step(); err != nil {
+			return err
+		}
+	}
+	m.CPU.PC = uint16(arch.MemoryMapping(arch.MemROMExtra12K))
+	r.m = m
+	return nil
+}
+
+func (r *runner) load(args []string) error {
+	data, err := os.ReadFile(filepath.Join(r.env.Dir, args[0]))
+	if err != nil {
+		return err
+	}
+	var program fahivets.HexData
+	if strings.EqualFold(filepath.Ext(args[0]), ".hex") {
+		program, err = fahivets.ReadHex(bytes.NewReader(data))
+	} else {
+		var rks fahivets.RksData
+		rks, err = fahivets.ReadRks(bytes.NewReader(data))
+		program = rks.AsHex()
+	}
+	if err != nil {
+		return err
+	}
+	start := program.Start
+	if len(args) > 1 {
+		addr, _ := strconv.ParseUint(args[1], 0, 16)
+		start = uint16(addr)
+	}
+	program.Load(r.m.CPU.Memory[:])
+	r.m.CPU.Exec(arch.JMP(start))
+	return nil
+}
+
+func (r *runner) wait(c Condition, timeout uint64) error {
+	m := r.m
+	deadline := m.CPU.Cycles + timeout
+	nextFrame := m.CPU.Cycles
+	for {
+		frame := m.CPU.Cycles >= nextFrame
+		if frame {
+			nextFrame += frameCycles
+		}
+		if (frame || !c.Screen()) && c.Met(m) {
+			return nil
+		}
+		if m.CPU.Cycles >= deadline {
+			return fmt.Errorf("%s is not met in %d cycles%s", c, timeout, r.state(c))
+		}
+		if _, _, err := m.Step(); err != nil {
+			return err
+		}
+	}
+}
+
+// state describes the machine state for the failed condition.
+func (r *runner) state(c Condition) string {
+	if c.Screen() {
+		return fmt.Sprintf(", the screen is:\n%s", strings.Join(r.m.Display.Text(), "\n"))
+	}
+	return fmt.Sprintf(", the CPU is %s", &r.m.CPU)
+}
+
+func (r *runner) snapshot(name string) error {
+	f, err := os.Create(filepath.Join(r.env.Output, name))
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, r.m.Display.Image()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,65 @@
+package scenario
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitFields(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{line: "", want: nil},
+		{line: "  run   10ms ", want: []string{"run", "10ms"}},
+		{line: `type "D0,F\r"`, want: []string{"type", "D0,F\r"}},
+		{line: `wait text="* МОНИТОР ?" 2s`, want: []string{"wait", "text=* МОНИТОР ?", "2s"}},
+	} {
+		got, err := splitFields(tc.line)
+		if err != nil {
+			t.Errorf("%q: %s", tc.line, err)
+		} else if !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %q; want %q", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	s, err := Read("ok", strings.NewReader("# comment\n\nboot\nrun 1s\npress Enter 20ms\nwait pc=0xc800\nassert mem[0x8ff4]=4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.steps) != 5 {
+		t.Errorf("got %d steps; want 5", len(s.steps))
+	}
+
+	for _, tc := range []struct {
+		text, err string
+	}{
+		{text: "jump 0", err: "bad:1: unknown step"},
+		{text: "boot\nrun", err: "bad:2: run: got 0 arguments"},
+		{text: "run 10us", err: "bad duration"},
+		{text: "press Nope", err: "unknown key"},
+		{text: "wait x=1", err: "unknown value"},
+		{text: "wait a=0x1ba", err: "out of range"},
+		{text: "assert mem[0x8ffd]=256", err: "out of range"},
+		{text: "wait pc=0x10000", err: "out of range"},
+		{text: `type "open`, err: "bad quoted text"},
+	} {
+		_, err := Read("bad", strings.NewReader(tc.text))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: got error %v; want %q", tc.text, err, tc.err)
+		}
+	}
+}
+
+func TestRunNotBooted(t *testing.T) {
+	s, err := Read("s", strings.NewReader("run 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(Env{}); err == nil || !strings.Contains(err.Error(), "not booted") {
+		t.Errorf("got error %v; want the machine is not booted", err)
+	}
+}
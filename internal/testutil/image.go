@@ -0,0 +1,137 @@
+package testutil
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	updateGolden = flag.Bool("update", false, "update the golden images instead of comparing them")
+	artifactsDir = flag.String("artifacts", filepath.Join(os.TempDir(), "fahivets-artifacts"),
+		"directory for the expected, actual and diff images of the failed comparisons")
+)
+
+// ImageOptions configures the golden image comparison.
+type ImageOptions struct {
+	// Tolerance is the maximal difference of a colour channel that is not reported.
+	Tolerance uint8
+	// Ignore are the regions excluded from the comparison, like a blinking cursor.
+	Ignore []image.Rectangle
+}
+
+func (o ImageOptions) ignored(p image.Point) bool {
+	for _, r := range o.Ignore {
+		if p.In(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffImages compares the decoded pixels of the images. It returns the number of the differing pixels and the
+// image highlighting them in red over the dimmed expected image, the ignored regions are tinted blue.
+// Images of different sizes are reported with an error.
+func DiffImages(want, got image.Image, opts ImageOptions) (int, *image.RGBA, error) {
+	wb, gb := want.Bounds(), got.Bounds()
+	if wb.Size() != gb.Size() {
+		return 0, nil, fmt.Errorf("got image size %v; want %v", gb.Size(), wb.Size())
+	}
+	diff := image.NewRGBA(image.Rect(0, 0, wb.Dx(), wb.Dy()))
+	n := 0
+	for y := range wb.Dy() {
+		for x := range wb.Dx() {
+			wc := color.RGBAModel.Convert(want.At(wb.Min.X+x, wb.Min.Y+y)).(color.RGBA)
+			gc := color.RGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.RGBA)
+			dim := uint8((uint16(wc.R) + uint16(wc.G) + uint16(wc.B)) / 3 / 4)
+			switch {
+			case opts.ignored(image.Pt(x, y)):
+				diff.SetRGBA(x, y, color.RGBA{R: dim, G: dim, B: 0x80 + dim, A: 0xFF})
+			case channelDiff(wc, gc) > opts.Tolerance:
+				diff.SetRGBA(x, y, color.RGBA{R: 0xFF, A: 0xFF})
+				n++
+			default:
+				diff.SetRGBA(x, y, color.RGBA{R: dim, G: dim, B: dim, A: 0xFF})
+			}
+		}
+	}
+	return n, diff, nil
+}
+
+func channelDiff(a, b color.RGBA) uint8 {
+	d := func(x, y uint8) uint8 { return max(x, y) - min(x, y) }
+	return max(d(a.R, b.R), d(a.G, b.G), d(a.B, b.B), d(a.A, b.A))
+}
+
+// CheckImage compares the image with the golden PNG file. On a mismatch it writes the expected, actual and diff
+// images to the artifacts directory (set with the -artifacts flag). With the -update flag the golden file is
+// rewritten instead.
+func CheckImage(t testing.TB, path string, got image.Image, opts ImageOptions) {
+	t.Helper()
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writePNG(path, got); err != nil {
+			t.Fatal(err)
+		}
+		t.Log("updated", path)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%s, run the test with -update to create it", err)
+	}
+	want, err := png.Decode(f)
+	_ = f.Close()
+	if err != nil {
+		t.Fatalf("%s: %s", path, err)
+	}
+
+	n, diff, err := DiffImages(want, got, opts)
+	if err == nil && n == 0 {
+		return
+	}
+	if err != nil {
+		t.Errorf("%s: %s", path, err)
+	} else {
+		t.Errorf("%s: %d pixels differ, run the test with -update to regenerate", path, n)
+	}
+
+	dir := filepath.Join(*artifactsDir, strings.NewReplacer("/", "-", " ", "_").Replace(t.Name()))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	kinds, images := []string{"expected", "actual", "diff"}, []image.Image{want, got, diff}
+	if diff == nil {
+		kinds, images = kinds[:2], images[:2]
+	}
+	for i, img := range images {
+		kind := kinds[i]
+		out := filepath.Join(dir, name+"-"+kind+".png")
+		if err := writePNG(out, img); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%s image written to %s", kind, out)
+	}
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,42 @@
+package testutil
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDiffImages(t *testing.T) {
+	want := image.NewGray(image.Rect(0, 0, 4, 4))
+	got := image.NewGray(image.Rect(0, 0, 4, 4))
+	got.SetGray(0, 0, color.Gray{Y: 3})
+	got.SetGray(3, 3, color.Gray{Y: 0xFF})
+	got.SetGray(1, 2, color.Gray{Y: 0xFF})
+
+	for _, tc := range []struct {
+		name string
+		opts ImageOptions
+		want int
+	}{
+		{name: "exact", want: 3},
+		{name: "tolerance", opts: ImageOptions{Tolerance: 3}, want: 2},
+		{name: "ignore", opts: ImageOptions{Tolerance: 3, Ignore: []image.Rectangle{image.Rect(2, 2, 4, 4)}}, want: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n, diff, err := DiffImages(want, got, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tc.want {
+				t.Errorf("got %d differing pixels; want %d", n, tc.want)
+			}
+			if c := diff.RGBAAt(1, 2); c != (color.RGBA{R: 0xFF, A: 0xFF}) {
+				t.Errorf("got diff colour %v; want red", c)
+			}
+		})
+	}
+
+	if _, _, err := DiffImages(want, image.NewGray(image.Rect(0, 0, 4, 5)), ImageOptions{}); err == nil {
+		t.Error("expected an error for different sizes")
+	}
+}
@@ -0,0 +1,180 @@
+// Package wav implements reading and writing of the RIFF WAVE audio files used for tape recordings and
+// sound exports.
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Audio is a mono signal with samples normalized to the [-1, 1] range.
+type Audio struct {
+	SampleRate int
+	Samples    []float64
+}
+
+const (
+	formatPCM   = 1
+	formatFloat = 3
+	// formatExtensible stores the actual format in the first 2 bytes of the sub-format GUID.
+	formatExtensible = 0xFFFE
+)
+
+// maxFormatSize limits the format chunk, the largest standard one (WAVE_FORMAT_EXTENSIBLE) is 40 bytes.
+const maxFormatSize = 1024
+
+type formatChunk struct {
+	Format        uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+// Read decodes a WAVE file. Integer PCM with 8, 16, 24, or 32 bits per sample and 32-bit float data are supported.
+// Multichannel audio is mixed down to mono.
+func Read(in io.Reader) (Audio, error) {
+	var (
+		res    Audio
+		header [12]byte
+	)
+	if _, err := io.ReadFull(in, header[:]); err != nil {
+		return res, fmt.Errorf("failed to read the header: %w", err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return res, errors.New("not a WAVE file")
+	}
+
+	var (
+		format    formatChunk
+		hasFormat bool
+	)
+	for {
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(in, chunkHeader[:]); err != nil {
+			return res, fmt.Errorf("failed to find the data chunk: %w", err)
+		}
+		id, size := string(chunkHeader[0:4]), int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+		// Chunks are padded to an even size.
+		padded := size + size%2
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return res, fmt.Errorf("format chunk is too short: %d bytes", size)
+			}
+			if size > maxFormatSize {
+				return res, fmt.Errorf("format chunk is too long: %d bytes", size)
+			}
+			body := make([]byte, padded)
+			if _, err := io.ReadFull(in, body); err != nil {
+				return res, fmt.Errorf("failed to read the format: %w", err)
+			}
+			format = formatChunk{
+				Format:        binary.LittleEndian.Uint16(body[0:]),
+				Channels:      binary.LittleEndian.Uint16(body[2:]),
+				SampleRate:    binary.LittleEndian.Uint32(body[4:]),
+				ByteRate:      binary.LittleEndian.Uint32(body[8:]),
+				BlockAlign:    binary.LittleEndian.Uint16(body[12:]),
+				BitsPerSample: binary.LittleEndian.Uint16(body[14:]),
+			}
+			if format.Format == formatExtensible && size >= 26 {
+				format.Format = binary.LittleEndian.Uint16(body[24:])
+			}
+			if format.SampleRate == 0 {
+				return res, errors.New("zero sample rate")
+			}
+			hasFormat = true
+		case "data":
+			if !hasFormat {
+				return res, errors.New("data chunk precedes the format")
+			}
+			// Truncated recordings are common, and streaming writers leave the size at 0xFFFFFFFF,
+			// so the declared size only limits the read.
+			data, err := io.ReadAll(io.LimitReader(in, size))
+			if err != nil {
+				return res, fmt.Errorf("failed to read the data: %w", err)
+			}
+			res.SampleRate = int(format.SampleRate)
+			res.Samples, err = decodeSamples(format, data)
+			return res, err
+		default:
+			if _, err := io.CopyN(io.Discard, in, padded); err != nil {
+				return res, fmt.Errorf("failed to skip chunk %q: %w", id, err)
+			}
+		}
+	}
+}
+
+func decodeSamples(format formatChunk, data []byte) ([]float64, error) {
+	if format.Channels == 0 {
+		return nil, errors.New("no channels")
+	}
+	bytesPerSample := int(format.BitsPerSample+7) / 8
+	var sample func(b []byte) float64
+	switch {
+	case format.Format == formatPCM && bytesPerSample == 1:
+		sample = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case format.Format == formatPCM && bytesPerSample == 2:
+		sample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }
+	case format.Format == formatPCM && bytesPerSample == 3:
+		sample = func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)) / (1 << 31)
+		}
+	case format.Format == formatPCM && bytesPerSample == 4:
+		sample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format.Format == formatFloat && bytesPerSample == 4:
+		sample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	default:
+		return nil, fmt.Errorf("unsupported sample format %d with %d bits", format.Format, format.BitsPerSample)
+	}
+
+	channels := int(format.Channels)
+	frameSize := bytesPerSample * channels
+	res := make([]float64, len(data)/frameSize)
+	for i := range res {
+		frame := data[i*frameSize:]
+		var sum float64
+		for ch := range channels {
+			sum += sample(frame[ch*bytesPerSample:])
+		}
+		res[i] = sum / float64(channels)
+	}
+	return res, nil
+}
+
+// Write encodes the audio as a 16-bit mono PCM WAVE file. Samples outside the [-1, 1] range are clipped.
+func Write(out io.Writer, a Audio) error {
+	const bytesPerSample = 2
+	dataSize := len(a.Samples) * bytesPerSample
+
+	buf := make([]byte, 44+dataSize)
+	copy(buf[0:], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(36+dataSize))
+	copy(buf[8:], "WAVE")
+	copy(buf[12:], "fmt ")
+	binary.LittleEndian.PutUint32(buf[16:], 16)
+	binary.LittleEndian.PutUint16(buf[20:], formatPCM)
+	binary.LittleEndian.PutUint16(buf[22:], 1)
+	binary.LittleEndian.PutUint32(buf[24:], uint32(a.SampleRate))
+	binary.LittleEndian.PutUint32(buf[28:], uint32(a.SampleRate*bytesPerSample))
+	binary.LittleEndian.PutUint16(buf[32:], bytesPerSample)
+	binary.LittleEndian.PutUint16(buf[34:], 8*bytesPerSample)
+	copy(buf[36:], "data")
+	binary.LittleEndian.PutUint32(buf[40:], uint32(dataSize))
+
+	for i, s := range a.Samples {
+		binary.LittleEndian.PutUint16(buf[44+i*bytesPerSample:], uint16(Quantize(s)))
+	}
+	_, err := out.Write(buf)
+	return err
+}
+
+// Quantize converts a normalized sample into a 16-bit PCM value.
+func Quantize(s float64) int16 {
+	s = math.Max(-1, math.Min(1, s))
+	return int16(math.Round(s * math.MaxInt16))
+}
@@ -0,0 +1,110 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Audio{SampleRate: 8000, Samples: []float64{0, 0.5, -0.5, 1, -1, 2}}
+	var buf bytes.Buffer
+	if err := Write(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.SampleRate != in.SampleRate {
+		t.Errorf("got sample rate %d; want %d", out.SampleRate, in.SampleRate)
+	}
+	want := []float64{0, 0.5, -0.5, 1, -1, 1}
+	if len(out.Samples) != len(want) {
+		t.Fatalf("got %d samples; want %d", len(out.Samples), len(want))
+	}
+	for i := range want {
+		if d := out.Samples[i] - want[i]; d > 0.001 || d < -0.001 {
+			t.Errorf("sample %d: got %f; want %f", i, out.Samples[i], want[i])
+		}
+	}
+}
+
+func TestReadStereo8Bit(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(0)) // Size is not validated.
+	buf.WriteString("WAVE")
+	buf.WriteString("LIST")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(3))
+	buf.Write([]byte{1, 2, 3, 0}) // Odd-sized chunk with padding.
+	buf.WriteString("fmt ")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(16))
+	_ = binary.Write(&buf, binary.LittleEndian, formatChunk{
+		Format: formatPCM, Channels: 2, SampleRate: 11025, ByteRate: 22050, BlockAlign: 2, BitsPerSample: 8,
+	})
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(4))
+	buf.Write([]byte{128, 192, 0, 0})
+
+	out, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Samples) != 2 || out.Samples[0] != 0.25 || out.Samples[1] != -1 {
+		t.Errorf("got samples %v; want [0.25 -1]", out.Samples)
+	}
+}
+
+// header writes the WAVE header and the format chunk of the declared size.
+func header(buf *bytes.Buffer, formatSize uint32, format formatChunk) {
+	buf.WriteString("RIFF")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(0))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	_ = binary.Write(buf, binary.LittleEndian, formatSize)
+	_ = binary.Write(buf, binary.LittleEndian, format)
+}
+
+func TestReadStreamed(t *testing.T) {
+	var buf bytes.Buffer
+	header(&buf, 16, formatChunk{Format: formatPCM, Channels: 1, SampleRate: 8000, BlockAlign: 1, BitsPerSample: 8})
+	buf.WriteString("data")
+	// Streaming writers do not know the data size.
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(0xFFFFFFFF))
+	buf.Write([]byte{128, 0, 255})
+
+	out, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Samples) != 3 {
+		t.Errorf("got %d samples; want 3", len(out.Samples))
+	}
+}
+
+func TestReadBadFormat(t *testing.T) {
+	good := formatChunk{Format: formatPCM, Channels: 1, SampleRate: 8000, BlockAlign: 1, BitsPerSample: 8}
+	zeroRate := good
+	zeroRate.SampleRate = 0
+	for _, tc := range []struct {
+		name   string
+		size   uint32
+		format formatChunk
+	}{
+		{name: "huge", size: 0xFFFFFFFF, format: good},
+		{name: "short", size: 8, format: good},
+		{name: "zero sample rate", size: 16, format: zeroRate},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			header(&buf, tc.size, tc.format)
+			buf.WriteString("data")
+			_ = binary.Write(&buf, binary.LittleEndian, uint32(1))
+			buf.WriteByte(128)
+			if _, err := Read(&buf); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package fahivets
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"rmazur.io/fahivets/arch"
+	"rmazur.io/fahivets/devices"
+)
+
+// Movie is a recording of the keyboard input that reproduces a session deterministically.
+// The session must start from the same state: the ROM contents and the loaded program are identified by their
+// hashes. The display hashes are stored periodically to verify the replay.
+//
+// The text format has a line per item:
+//
+//	fahivets-movie 1
+//	rom SHA256
+//	program SHA256
+//	length CYCLES
+//	key CYCLE CODE down|up
+//	frame CYCLE HASH
+//
+// The cycles are counted from the start of the recording. The program hash is "-" when no program is loaded.
+type Movie struct {
+	ROMHash     string
+	ProgramHash string
+	// Length is the number of the recorded cycles.
+	Length uint64
+	Keys   []MovieKey
+	Frames []MovieFrame
+}
+
+// MovieKey is a keyboard event of the movie.
+type MovieKey struct {
+	Cycle uint64
+	Code  devices.KeyCode
+	State devices.KeyState
+}
+
+// MovieFrame is the display hash at the cycle of the movie.
+type MovieFrame struct {
+	Cycle uint64
+	Hash  uint64
+}
+
+const movieHeader = "fahivets-movie 1"
+
+// romHash returns the hash of the ROM address space.
+func (c *Computer) romHash() string {
+	rom := c.CPU.Memory[arch.MemoryMapping(arch.MemROM2K):arch.MemoryMapping(arch.MemRegisters2K)]
+	sum := sha256.Sum256(rom)
+	return hex.EncodeToString(sum[:])
+}
+
+func programHash(program []byte) string {
+	if program == nil {
+		return "-"
+	}
+	sum := sha256.Sum256(program)
+	return hex.EncodeToString(sum[:])
+}
+
+// MovieRecorder records the keyboard events and the display hashes of the computer.
+type MovieRecorder struct {
+	c      *Computer
+	movie  *Movie
+	start  uint64
+	period uint64
+	event  *devices.Event
+	// unobserve stops recording the keyboard events.
+	unobserve func()
+}
+
+// RecordMovie starts recording a movie. The program is the content of the loaded program file, it may be nil.
+// The display hash is stored frameRate times per second of the emulated time.
+func (c *Computer) RecordMovie(program []byte, frameRate int) *MovieRecorder {
+	r := &MovieRecorder{
+		c:      c,
+		movie:  &Movie{ROMHash: c.romHash(), ProgramHash: programHash(program)},
+		start:  c.CPU.Cycles,
+		period: ClockFrequency / uint64(max(frameRate, 1)),
+	}
+	r.unobserve = c.Keyboard.Observe(func(code devices.KeyCode, state devices.KeyState) {
+		r.movie.Keys = append(r.movie.Keys, MovieKey{Cycle: c.CPU.Cycles - r.start, Code: code, State: state})
+	})
+	r.frame(r.start)
+	return r
+}
+
+func (r *MovieRecorder) frame(cycle uint64) {
+	r.movie.Frames = append(r.movie.Frames, MovieFrame{Cycle: cycle - r.start, Hash: r.c.Display.Hash()})
+	next := cycle + r.period
+	r.event = r.c.Scheduler.At(next, func() { r.frame(next) })
+}
+
+// Stop stops the recording and returns the movie.
+func (r *MovieRecorder) Stop() *Movie {
+	if r.movie.Length == 0 {
+		r.unobserve()
+		r.event.Cancel()
+		r.movie.Length = max(r.c.CPU.Cycles-r.start, 1)
+	}
+	return r.movie
+}
+
+// MoviePlayer feeds the movie events to the keyboard at the recorded cycles, and verifies the display hashes.
+type MoviePlayer struct {
+	c     *Computer
+	movie *Movie
+	start uint64
+	// checked is the number of the verified frames.
+	checked int
+	err     error
+}
+
+// PlayMovie schedules the movie events starting from the current cycle. It fails if the ROM or the program
+// differ from the recorded ones.
+func (c *Computer) PlayMovie(m *Movie, program []byte) (*MoviePlayer, error) {
+	if h := c.romHash(); h != m.ROMHash {
+		return nil, fmt.Errorf("ROM hash %s differs from the movie one %s", h, m.ROMHash)
+	}
+	if h := programHash(program); h != m.ProgramHash {
+		return nil, fmt.Errorf("program hash %s differs from the movie one %s", h, m.ProgramHash)
+	}
+	p := &MoviePlayer{c: c, movie: m, start: c.CPU.Cycles}
+	// Frames are hashed before the keys are applied at the same cycle, like it happens during the recording.
+	for i, f := range m.Frames {
+		p.at(f.Cycle, func() { p.verify(i) })
+	}
+	for _, k := range m.Keys {
+		p.at(k.Cycle, func() { c.Keyboard.Event(k.Code, k.State) })
+	}
+	return p, nil
+}
+
+// at calls f at the movie cycle. The events of the first cycle are applied immediately, as the scheduler would
+// fire them only after the next instruction.
+func (p *MoviePlayer) at(cycle uint64, f func()) {
+	if cycle == 0 {
+		f()
+		return
+	}
+	p.c.Scheduler.At(p.start+cycle, f)
+}
+
+func (p *MoviePlayer) verify(i int) {
+	f := p.movie.Frames[i]
+	if h := p.c.Display.Hash(); h != f.Hash && p.err == nil {
+		p.err = fmt.Errorf("frame %d at cycle %d: got display hash %016x; want %016x", i, f.Cycle, h, f.Hash)
+	}
+	p.checked++
+}
+
+// End returns the cycle when the movie ends.
+func (p *MoviePlayer) End() uint64 { return p.start + p.movie.Length }
+
+// Verify returns the first display hash mismatch, or an error if not all the frames were checked yet.
+func (p *MoviePlayer) Verify() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.checked < len(p.movie.Frames) {
+		return fmt.Errorf("only %d of %d frames were played", p.checked, len(p.movie.Frames))
+	}
+	return nil
+}
+
+// WriteTo writes the movie in the text format.
+func (m *Movie) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	fmt.Fprintln(&sb, movieHeader)
+	fmt.Fprintln(&sb, "rom", m.ROMHash)
+	fmt.Fprintln(&sb, "program", m.ProgramHash)
+	fmt.Fprintln(&sb, "length", m.Length)
+	keys, frames := m.Keys, m.Frames
+	for len(keys) > 0 || len(frames) > 0 {
+		if len(keys) > 0 && (len(frames) == 0 || keys[0].Cycle < frames[0].Cycle) {
+			state := "up"
+			if keys[0].State == devices.KeyStateDown {
+				state = "down"
+			}
+			fmt.Fprintf(&sb, "key %d 0x%02x %s\n", keys[0].Cycle, byte(keys[0].Code), state)
+			keys = keys[1:]
+		} else {
+			fmt.Fprintf(&sb, "frame %d %016x\n", frames[0].Cycle, frames[0].Hash)
+			frames = frames[1:]
+		}
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// ReadMovie reads the movie in the text format.
+func ReadMovie(r io.Reader) (*Movie, error) {
+	var m Movie
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if err := m.parseLine(line, fields); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if m.ROMHash == "" {
+		return nil, fmt.Errorf("no movie header")
+	}
+	if !slices.IsSortedFunc(m.Keys, func(a, b MovieKey) int { return cmp.Compare(a.Cycle, b.Cycle) }) ||
+		!slices.IsSortedFunc(m.Frames, func(a, b MovieFrame) int { return cmp.Compare(a.Cycle, b.Cycle) }) {
+		return nil, fmt.Errorf("movie events are not sorted by cycles")
+	}
+	return &m, nil
+}
+
+func (m *Movie) parseLine(line int, fields []string) error {
+	if line == 1 {
+		if strings.Join(fields, " ") != movieHeader {
+			return fmt.Errorf("got header %q; want %q", strings.Join(fields, " "), movieHeader)
+		}
+		return nil
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	args := fields[1:]
+	want := map[string]int{"rom": 1, "program": 1, "length": 1, "key": 3, "frame": 2}[fields[0]]
+	if want == 0 {
+		return fmt.Errorf("unknown item %q", fields[0])
+	}
+	if len(args) != want {
+		return fmt.Errorf("%s: got %d arguments; want %d", fields[0], len(args), want)
+	}
+
+	var err error
+	switch fields[0] {
+	case "rom":
+		m.ROMHash = args[0]
+	case "program":
+		m.ProgramHash = args[0]
+	case "length":
+		m.Length, err = strconv.ParseUint(args[0], 10, 64)
+	case "key":
+		var k MovieKey
+		var code uint64
+		if k.Cycle, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+			return err
+		}
+		if code, err = strconv.ParseUint(args[1], 0, 8); err != nil {
+			return err
+		}
+		k.Code = devices.KeyCode(code)
+		if !k.Code.Valid() {
+			return fmt.Errorf("unknown key code 0x%02x", code)
+		}
+		switch args[2] {
+		case "down":
+			k.State = devices.KeyStateDown
+		case "up":
+			k.State = devices.KeyStateUp
+		default:
+			return fmt.Errorf("unknown key state %q", args[2])
+		}
+		m.Keys = append(m.Keys, k)
+	case "frame":
+		var f MovieFrame
+		if f.Cycle, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+			return err
+		}
+		if f.Hash, err = strconv.ParseUint(args[1], 16, 64); err != nil {
+			return err
+		}
+		m.Frames = append(m.Frames, f)
+	}
+	return err
+}
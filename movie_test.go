@@ -0,0 +1,109 @@
+package fahivets_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"rmazur.io/fahivets"
+	"rmazur.io/fahivets/arch"
+	"rmazur.io/fahivets/devices"
+)
+
+func TestMovie(t *testing.T) {
+	startMonitor := func() *fahivets.Computer {
+		m := initWithBootloader(t)
+		m.CPU.PC = uint16(arch.MemoryMapping(arch.MemROMExtra12K))
+		return m
+	}
+	program := []byte("program")
+
+	m := startMonitor()
+	rec := m.RecordMovie(program, 50)
+	if err := m.RunFor(1_000_000); err != nil {
+		t.Fatal(err)
+	}
+	done, err := m.Keyboard.Type("D0,F\r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RunUntil(done + 1_000_000); err != nil {
+		t.Fatal(err)
+	}
+	movie := rec.Stop()
+	if len(movie.Keys) == 0 || len(movie.Frames) == 0 {
+		t.Fatalf("got %d keys, %d frames recorded", len(movie.Keys), len(movie.Frames))
+	}
+	wantText := m.Display.Text()
+	keys := len(movie.Keys)
+	m.Keyboard.Event(devices.MatrixKeyCode(1, 1), devices.KeyStateDown)
+	if len(movie.Keys) != keys {
+		t.Error("got a key recorded after the recording is stopped")
+	}
+
+	var buf bytes.Buffer
+	if _, err := movie.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	movie, err = fahivets.ReadMovie(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	play := func(movie *fahivets.Movie) (*fahivets.Computer, error) {
+		m := startMonitor()
+		p, err := m.PlayMovie(movie, program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.RunUntil(p.End()); err != nil {
+			t.Fatal(err)
+		}
+		return m, p.Verify()
+	}
+
+	replayed, err := play(movie)
+	if err != nil {
+		t.Error(err)
+	}
+	if got := replayed.Display.Text(); strings.Join(got, "\n") != strings.Join(wantText, "\n") {
+		t.Errorf("got screen %q; want %q", got, wantText)
+	}
+
+	t.Run("verify", func(t *testing.T) {
+		changed := *movie
+		changed.Frames = append(changed.Frames[:0:0], movie.Frames...)
+		changed.Frames[len(changed.Frames)-1].Hash++
+		if _, err := play(&changed); err == nil || !strings.Contains(err.Error(), "display hash") {
+			t.Errorf("got error %v; want a display hash mismatch", err)
+		}
+	})
+
+	t.Run("program", func(t *testing.T) {
+		m := startMonitor()
+		if _, err := m.PlayMovie(movie, []byte("other")); err == nil {
+			t.Error("expected an error for a different program")
+		}
+	})
+}
+
+func TestReadMovie(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, err string
+	}{
+		{name: "header", in: "fahivets-movie 2\n", err: "header"},
+		{name: "item", in: "fahivets-movie 1\nrom 00\nsave 1\n", err: `unknown item "save"`},
+		{name: "arguments", in: "fahivets-movie 1\nrom 00\nkey 1 0x13\n", err: "got 2 arguments; want 3"},
+		{name: "state", in: "fahivets-movie 1\nrom 00\nkey 1 0x13 left\n", err: "unknown key state"},
+		{name: "row", in: "fahivets-movie 1\nrom 00\nkey 1 0x0f down\n", err: "unknown key code 0x0f"},
+		{name: "column", in: "fahivets-movie 1\nrom 00\nkey 1 0xc0 down\n", err: "unknown key code 0xc0"},
+		{name: "order", in: "fahivets-movie 1\nrom 00\nframe 2 00\nframe 1 00\n", err: "not sorted"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := fahivets.ReadMovie(strings.NewReader(tc.in))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v; want %q", err, tc.err)
+			}
+		})
+	}
+}
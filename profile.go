@@ -0,0 +1,53 @@
+package fahivets
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+
+	"rmazur.io/fahivets/devices"
+)
+
+// Profile describes a variant of the machine.
+type Profile struct {
+	Name string
+	// ColorMode and Palette configure the display.
+	ColorMode devices.ColorMode
+	Palette   color.Palette
+}
+
+var (
+	// ProfileStandard is the stock Фахівець-85 with the monochrome display.
+	ProfileStandard = Profile{Name: "standard"}
+	// ProfileColor is the colour modification that selects the ink colour with the port C pins.
+	ProfileColor = Profile{Name: "color", ColorMode: devices.ColorPortC, Palette: devices.Palette8}
+	// ProfileMX has the Specialist-MX style colour register with 16 colours.
+	ProfileMX = Profile{Name: "mx", ColorMode: devices.ColorRegister, Palette: devices.Palette16}
+)
+
+var profiles = map[string]Profile{}
+
+func init() {
+	for _, p := range []Profile{ProfileStandard, ProfileColor, ProfileMX} {
+		profiles[p.Name] = p
+	}
+}
+
+// Profiles returns the names of the known profiles.
+func Profiles() []string {
+	var res []string
+	for name := range profiles {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// LookupProfile finds the profile by its name.
+func LookupProfile(name string) (Profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return p, fmt.Errorf("unknown profile %q", name)
+	}
+	return p, nil
+}
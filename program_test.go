@@ -2,12 +2,11 @@ package fahivets_test
 
 import (
 	"bytes"
-	"fmt"
 	"image/png"
 	"io"
 	"os"
 	"path/filepath"
-	"runtime"
+	"slices"
 	"testing"
 
 	"rmazur.io/fahivets"
@@ -69,41 +68,24 @@ func TestBootloader(t *testing.T) {
 	displayStart, displayEnd := arch.MemoryMappingRange(arch.MemDisplay12K)
 	_ = m.CPU.Memory.DumpSparse(tOut, displayStart, displayEnd+1)
 
-	var outImg bytes.Buffer
-	err := png.Encode(&outImg, m.Display.Image())
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	sample := readData(t, "display-sample.png")
-	if bytes.Compare(sample, outImg.Bytes()) != 0 {
-		t.Error("output image is different from the sample")
-		out, err := os.Create("error.png")
-		if err != nil {
-			t.Fatal(err)
-		}
-		t.Cleanup(func() { _ = out.Close() })
-		_, err = io.Copy(out, &outImg)
-		if err != nil {
-			t.Fatal(err)
-		}
-	}
+	testutil.CheckImage(t, "testdata/display-sample.png", m.Display.Image(), testutil.ImageOptions{})
 
 	t.Log("Running monitor")
 	m.CPU.PC = uint16(monitorStart)
 
 	advance(t, m, 16_000*5, false)
-	captureDisplay(t, m, "test.png")
+	testutil.CheckImage(t, "testdata/golden/monitor.png", m.Display.Image(), testutil.ImageOptions{})
+	if text := m.Display.Text(); !slices.Contains(text, "* МОНИТОР ?") {
+		t.Errorf("no monitor prompt on the screen: %q", text)
+	}
 
 	// 0xc269
 	t.Log("check keypress subroutine")
 	m.CPU.PC = 0xc269
 	keyCode := devices.MatrixKeyCode(3, 3)
 	m.Keyboard.Event(keyCode, devices.KeyStateDown)
-	runtime.Gosched()
 	advance(t, m, 9, true)
 	m.Keyboard.Event(keyCode, devices.KeyStateUp)
-	runtime.Gosched()
 	t.Log("key is up")
 	advance(t, m, 255*80, true)
 }
@@ -112,28 +94,53 @@ func TestGames(t *testing.T) {
 	rainGame := readRks(t, "progs/rain.rks")
 	chessGame := readRks(t, "progs/chess4.rks")
 
-	run := func(t *testing.T, data fahivets.RksData, start int, steps int, dirName, prefix string) *fahivets.Computer {
+	start := func(t *testing.T, data fahivets.RksData, start int) (*fahivets.Computer, *fahivets.Recorder) {
 		m := initWithBootloader(t)
 		copy(m.CPU.Memory[data.StartAddress:], data.Content)
 		m.CPU.PC = uint16(start)
-		advance(t, m, steps, false)
-		captureDisplay(t, m, fmt.Sprintf("testdata/%s/%s-test-%d.png", dirName, prefix, start))
-		return m
+		return m, m.Record(50)
+	}
+	runFor := func(t *testing.T, m *fahivets.Computer, cycles uint64) {
+		t.Helper()
+		if err := m.RunFor(cycles); err != nil {
+			t.Fatalf("%s: %s", &m.CPU, err)
+		}
 	}
 
 	t.Run("chess/run", func(t *testing.T) {
-		run(t, chessGame, 0, 10000, "examples", "chess")
+		// The game gets to the instructions that are not implemented yet after it fills the screen with
+		// the board background, so only its start is checked.
+		m, rec := start(t, chessGame, 0)
+		boot := m.Display.Image()
+		advance(t, m, 900_000, false)
+		rec.Stop()
+		background := m.Display.Image()
+		testutil.CheckImage(t, "testdata/golden/chess-start.png", background, testutil.ImageOptions{})
+		if n, _, err := testutil.DiffImages(boot, background, testutil.ImageOptions{}); err != nil || n == 0 {
+			t.Errorf("the game does not draw on the screen: %d pixels differ, %v", n, err)
+		}
+		captureRecording(t, rec, "testdata/examples/chess-test-0.gif")
 	})
 
 	t.Run("rain/run", func(t *testing.T) {
-		m := run(t, rainGame, 48, 32000, "examples", "rain")
+		m, rec := start(t, rainGame, int(rainGame.StartAddress))
+		runFor(t, m, fahivets.ClockFrequency)
+		title := m.Display.Image()
+		testutil.CheckImage(t, "testdata/golden/rain-title.png", title, testutil.ImageOptions{})
+
+		// The key 1 shows the instructions.
 		digit1 := devices.MatrixKeyCode(4, 10)
 		m.Keyboard.Event(digit1, devices.KeyStateDown)
-		runtime.Gosched()
-		advance(t, m, 256, true)
+		runFor(t, m, fahivets.ClockFrequency/50)
 		m.Keyboard.Event(digit1, devices.KeyStateUp)
-		runtime.Gosched()
-		advance(t, m, 16000, false)
+		runFor(t, m, fahivets.ClockFrequency)
+		rec.Stop()
+		game := m.Display.Image()
+		testutil.CheckImage(t, "testdata/golden/rain-game.png", game, testutil.ImageOptions{})
+		if n, _, err := testutil.DiffImages(title, game, testutil.ImageOptions{}); err != nil || n == 0 {
+			t.Errorf("the screen is not changed by the key press: %d pixels differ, %v", n, err)
+		}
+		captureRecording(t, rec, "testdata/examples/rain-test.gif")
 	})
 }
 
@@ -164,7 +171,7 @@ func advance(t *testing.T, m *fahivets.Computer, steps int, debug bool) {
 	}
 }
 
-func captureDisplay(t *testing.T, m *fahivets.Computer, name string) {
+func captureRecording(t *testing.T, rec *fahivets.Recorder, name string) {
 	t.Helper()
 	f, err := os.Create(name)
 	if err != nil {
@@ -176,11 +183,10 @@ func captureDisplay(t *testing.T, m *fahivets.Computer, name string) {
 		}
 	})
 
-	err = png.Encode(f, m.Display.Image())
-	if err != nil {
+	if err := rec.WriteGIF(f); err != nil {
 		t.Error(err)
 	}
-	t.Log("display captured in", name)
+	t.Logf("%d frames recorded in %s", rec.Frames(), name)
 }
 
 func TestPrograms(t *testing.T) {
@@ -200,3 +206,115 @@ func TestPrograms(t *testing.T) {
 		})
 	}
 }
+
+func TestBeep(t *testing.T) {
+	m := initWithBootloader(t)
+	m.Speaker.Pull(make([]float32, m.Speaker.Buffered()))
+
+	// Call the bootloader beep subroutine and return to an infinite loop.
+	const loopAddress = 0x3000
+	arch.EncodeInstructions([]arch.Instruction{arch.JMP(loopAddress)}, m.CPU.Memory[loopAddress:])
+	m.CPU.SP = 0x3EFE
+	m.CPU.Memory[m.CPU.SP], m.CPU.Memory[m.CPU.SP+1] = loopAddress&0xFF, loopAddress>>8
+	m.CPU.PC = 0xc170
+
+	for m.CPU.PC != loopAddress {
+		advance(t, m, 1, false)
+	}
+
+	samples := make([]float32, m.Speaker.Buffered())
+	m.Speaker.Pull(samples)
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			crossings++
+		}
+	}
+	duration := float64(len(samples)) / fahivets.AudioSampleRate
+	t.Logf("beep: %d samples, ~%.0f Hz", len(samples), float64(crossings)/2/duration)
+	if crossings < 10 {
+		t.Errorf("got %d zero crossings; want a tone", crossings)
+	}
+}
+
+func TestDeterminism(t *testing.T) {
+	rainGame := readRks(t, "progs/rain.rks")
+	digit1 := devices.MatrixKeyCode(4, 10)
+
+	run := func() []byte {
+		m := initWithBootloader(t)
+		copy(m.CPU.Memory[rainGame.StartAddress:], rainGame.Content)
+		m.CPU.PC = 48
+		advance(t, m, 32000, false)
+		m.Keyboard.Event(digit1, devices.KeyStateDown)
+		advance(t, m, 256, false)
+		m.Keyboard.Event(digit1, devices.KeyStateUp)
+		advance(t, m, 16000, false)
+
+		var frame bytes.Buffer
+		if err := png.Encode(&frame, m.Display.Image()); err != nil {
+			t.Fatal(err)
+		}
+		return frame.Bytes()
+	}
+
+	if !bytes.Equal(run(), run()) {
+		t.Error("frames are different for the same input")
+	}
+}
+
+func TestRegisterSwitch(t *testing.T) {
+	const registerFlag = 0x8ff4
+
+	m := initWithBootloader(t)
+	m.CPU.PC = uint16(arch.MemoryMapping(arch.MemROMExtra12K))
+	// Let the monitor get to the keyboard input.
+	advance(t, m, 300_000, false)
+
+	press := func(code devices.KeyCode) {
+		m.Keyboard.Event(code, devices.KeyStateDown)
+		advance(t, m, 100_000, false)
+		m.Keyboard.Event(code, devices.KeyStateUp)
+		advance(t, m, 100_000, false)
+	}
+
+	press(devices.KeyRus)
+	if v := m.CPU.Memory[registerFlag]; v != 4 {
+		t.Errorf("got register flag 0x%02x after РУС; want 0x04", v)
+	}
+	press(devices.KeyShift)
+	if v := m.CPU.Memory[registerFlag]; v != 2 {
+		t.Errorf("got register flag 0x%02x after НР; want 0x02", v)
+	}
+}
+
+func TestType(t *testing.T) {
+	m := initWithBootloader(t)
+	m.CPU.PC = uint16(arch.MemoryMapping(arch.MemROMExtra12K))
+	advance(t, m, 300_000, false)
+
+	const text = "Hi, Привет-85! 2+2=4"
+	done, err := m.Keyboard.Type(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The monitor returns the code of the read character from one of these addresses.
+	var got []byte
+	for m.CPU.Cycles < done {
+		switch m.CPU.PC {
+		case 0xc2fc, 0xc308, 0xc30b:
+			got = append(got, m.CPU.Registers.A)
+		}
+		if _, _, err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []byte("HI, \x70\x72\x69\x77\x65\x74-85! 2+2=4")
+	if string(got) != string(want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if _, err := m.Keyboard.Type("~"); err == nil {
+		t.Error("expected an error for an unsupported character")
+	}
+}
@@ -0,0 +1,231 @@
+package fahivets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+
+	"rmazur.io/fahivets/devices"
+)
+
+// Recorder captures the display frames at a fixed rate of the emulated time and encodes them as an animation.
+// The frames are grabbed only while the computer runs: the timing reflects the emulated time, not the wall time.
+// Identical consecutive frames are stored once, extending the previous frame delay.
+type Recorder struct {
+	c       *Computer
+	period  uint64
+	palette color.Palette
+
+	start, end uint64
+	event      *devices.Event
+	frames     []recordedFrame
+}
+
+type recordedFrame struct {
+	img   *image.Paletted
+	cycle uint64
+}
+
+// Record starts capturing the display frames with the frame rate per second of the emulated time.
+// The first frame is captured immediately.
+func (c *Computer) Record(frameRate int) *Recorder {
+	r := &Recorder{
+		c:       c,
+		period:  ClockFrequency / uint64(max(frameRate, 1)),
+		palette: color.Palette{color.Black, color.White},
+		start:   c.CPU.Cycles,
+	}
+	if c.Display.ColorMode() != devices.ColorNone {
+		r.palette = c.Display.Palette
+	}
+	r.capture(r.start)
+	return r
+}
+
+func (r *Recorder) capture(cycle uint64) {
+	src := r.c.Display.Image()
+	img := image.NewPaletted(src.Bounds(), r.palette)
+	if gray, ok := src.(*image.Gray); ok {
+		// The monochrome pixels are either black or white.
+		for i, v := range gray.Pix {
+			img.Pix[i] = v >> 7
+		}
+	} else {
+		draw.Draw(img, img.Bounds(), src, image.Point{}, draw.Src)
+	}
+	if n := len(r.frames); n == 0 || !bytes.Equal(r.frames[n-1].img.Pix, img.Pix) {
+		r.frames = append(r.frames, recordedFrame{img: img, cycle: cycle})
+	}
+	next := cycle + r.period
+	r.event = r.c.Scheduler.At(next, func() { r.capture(next) })
+}
+
+// Stop stops capturing the frames. The last frame lasts until this moment.
+func (r *Recorder) Stop() {
+	if r.event != nil {
+		r.event.Cancel()
+		r.event = nil
+		r.end = r.c.CPU.Cycles
+	}
+}
+
+// Frames returns the number of the distinct frames captured.
+func (r *Recorder) Frames() int { return len(r.frames) }
+
+// endCycle returns the cycle the last frame lasts until.
+func (r *Recorder) endCycle() uint64 {
+	if r.event != nil {
+		return r.c.CPU.Cycles
+	}
+	return r.end
+}
+
+// delays returns the frame delays in the units of 1/perSecond of a second. The delays are computed from the
+// frame timestamps rounded to the units, so that the rounding errors do not accumulate.
+func (r *Recorder) delays(perSecond uint64) []int {
+	at := func(cycle uint64) int { return int((cycle - r.start) * perSecond / ClockFrequency) }
+	res := make([]int, len(r.frames))
+	for i, f := range r.frames {
+		end := r.endCycle()
+		if i+1 < len(r.frames) {
+			end = r.frames[i+1].cycle
+		}
+		res[i] = at(end) - at(f.cycle)
+	}
+	return res
+}
+
+// WriteGIF encodes the captured frames as an animated GIF. The GIF delays have the precision of 1/100 of a second.
+func (r *Recorder) WriteGIF(w io.Writer) error {
+	if len(r.frames) == 0 {
+		return errors.New("no frames recorded")
+	}
+	anim := gif.GIF{Delay: r.delays(100)}
+	for _, f := range r.frames {
+		anim.Image = append(anim.Image, f.img)
+	}
+	return gif.EncodeAll(w, &anim)
+}
+
+// WriteAPNG encodes the captured frames as an animated PNG with the delays in milliseconds.
+func (r *Recorder) WriteAPNG(w io.Writer) error {
+	if len(r.frames) == 0 {
+		return errors.New("no frames recorded")
+	}
+	enc := apngWriter{w: w}
+	delays := r.delays(1000)
+	for i, f := range r.frames {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, f.img); err != nil {
+			return err
+		}
+		chunks, err := readPngChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			enc.header(chunks, len(r.frames))
+		}
+		enc.frame(chunks, f.img.Bounds(), delays[i], i == 0)
+	}
+	enc.chunk("IEND", nil)
+	return enc.err
+}
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func readPngChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a PNG")
+	}
+	var res []pngChunk
+	for data = data[len(pngSignature):]; len(data) >= 12; {
+		n := int(binary.BigEndian.Uint32(data))
+		if len(data) < 12+n {
+			return nil, fmt.Errorf("truncated PNG chunk %q", data[4:8])
+		}
+		res = append(res, pngChunk{typ: string(data[4:8]), data: data[8 : 8+n]})
+		data = data[12+n:]
+	}
+	return res, nil
+}
+
+// apngWriter assembles an animated PNG from the chunks of the PNG images encoded with the same palette.
+type apngWriter struct {
+	w   io.Writer
+	seq uint32
+	err error
+}
+
+func (a *apngWriter) chunk(typ string, data []byte) {
+	if a.err != nil {
+		return
+	}
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	buf = append(buf, typ...)
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	_, a.err = a.w.Write(buf)
+}
+
+// header writes the signature, the animation control chunk, and the chunks preceding the image data.
+func (a *apngWriter) header(chunks []pngChunk, frames int) {
+	if _, a.err = a.w.Write(pngSignature); a.err != nil {
+		return
+	}
+	for _, c := range chunks {
+		switch c.typ {
+		case "IDAT", "IEND":
+			continue
+		}
+		a.chunk(c.typ, c.data)
+		if c.typ == "IHDR" {
+			// The number of frames, and playing them infinitely.
+			a.chunk("acTL", binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, uint32(frames)), 0))
+		}
+	}
+}
+
+// frame writes the frame control chunk and the image data. The first frame data is the default image.
+func (a *apngWriter) frame(chunks []pngChunk, bounds image.Rectangle, delayMs int, first bool) {
+	num, den := delayMs, 1000
+	for num > 0xFFFF && den > 1 {
+		num, den = num/10, den/10
+	}
+	num = min(num, 0xFFFF)
+	fc := binary.BigEndian.AppendUint32(nil, a.seq)
+	fc = binary.BigEndian.AppendUint32(fc, uint32(bounds.Dx()))
+	fc = binary.BigEndian.AppendUint32(fc, uint32(bounds.Dy()))
+	fc = binary.BigEndian.AppendUint32(fc, 0) // X offset.
+	fc = binary.BigEndian.AppendUint32(fc, 0) // Y offset.
+	fc = binary.BigEndian.AppendUint16(fc, uint16(num))
+	fc = binary.BigEndian.AppendUint16(fc, uint16(den))
+	fc = append(fc, 0, 0) // No disposal, the frame replaces the area.
+	a.seq++
+	a.chunk("fcTL", fc)
+
+	for _, c := range chunks {
+		if c.typ != "IDAT" {
+			continue
+		}
+		if first {
+			a.chunk("IDAT", c.data)
+			continue
+		}
+		a.chunk("fdAT", append(binary.BigEndian.AppendUint32(nil, a.seq), c.data...))
+		a.seq++
+	}
+}
@@ -0,0 +1,84 @@
+package fahivets_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"slices"
+	"testing"
+
+	"rmazur.io/fahivets"
+	"rmazur.io/fahivets/arch"
+)
+
+func TestRecorder(t *testing.T) {
+	m := fahivets.NewComputer()
+	// Infinite loop at the address 0.
+	arch.EncodeInstructions([]arch.Instruction{arch.NOP(), arch.JMP(0)}, m.CPU.Memory[:])
+	start, _ := arch.MemoryMappingRange(arch.MemDisplay12K)
+
+	rec := m.Record(50) // A frame every 40000 cycles.
+	if err := m.RunUntil(100_000); err != nil {
+		t.Fatal(err)
+	}
+	m.CPU.Memory[start] = 0x80
+	if err := m.RunUntil(200_000); err != nil {
+		t.Fatal(err)
+	}
+	rec.Stop()
+	// Stopped recorder does not capture the frames.
+	m.CPU.Memory[start] = 0
+	if err := m.RunUntil(300_000); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Frames() != 2 {
+		t.Errorf("got %d frames; want 2", rec.Frames())
+	}
+
+	var out bytes.Buffer
+	if err := rec.WriteGIF(&out); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second frame is captured at the cycle 120000.
+	if want := []int{6, 4}; !slices.Equal(anim.Delay, want) {
+		t.Errorf("got GIF delays %v; want %v", anim.Delay, want)
+	}
+	if len(anim.Image) == 2 && color.GrayModel.Convert(anim.Image[1].At(0, 0)) != (color.Gray{Y: 0xFF}) {
+		t.Errorf("got %v in the second frame; want white", anim.Image[1].At(0, 0))
+	}
+
+	out.Reset()
+	if err := rec.WriteAPNG(&out); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.GrayModel.Convert(img.At(0, 0)); c != (color.Gray{}) {
+		t.Errorf("got %v in the default image; want black", c)
+	}
+	var chunks []string
+	var delays []uint16
+	for data := out.Bytes()[8:]; len(data) >= 12; {
+		n := binary.BigEndian.Uint32(data)
+		typ := string(data[4:8])
+		chunks = append(chunks, typ)
+		if typ == "fcTL" {
+			delays = append(delays, binary.BigEndian.Uint16(data[8+20:]))
+		}
+		data = data[12+n:]
+	}
+	if want := []string{"IHDR", "acTL", "PLTE", "fcTL", "IDAT", "fcTL", "fdAT", "IEND"}; !slices.Equal(chunks, want) {
+		t.Errorf("got APNG chunks %v; want %v", chunks, want)
+	}
+	if want := []uint16{60, 40}; !slices.Equal(delays, want) {
+		t.Errorf("got APNG delays %v ms; want %v", delays, want)
+	}
+}
@@ -47,3 +47,28 @@ func ReadRks(in io.Reader) (data RksData, err error) {
 	}
 	return
 }
+
+// RksChecksum calculates the checksum of the program content the way the Фахівець monitor does it.
+func RksChecksum(content []byte) uint16 {
+	var sum uint16
+	for i, b := range content {
+		if i == len(content)-1 {
+			// The last byte affects the lower part only.
+			sum = sum&0xFF00 | uint16(byte(sum)+b)
+		} else {
+			sum += uint16(b) * 0x101
+		}
+	}
+	return sum
+}
+
+// WriteRks writes the data in the same format ReadRks expects.
+func WriteRks(out io.Writer, data RksData) error {
+	buf := make([]byte, 4, len(data.Content)+6)
+	binary.LittleEndian.PutUint16(buf[0:2], data.StartAddress)
+	binary.LittleEndian.PutUint16(buf[2:4], data.EndAddress)
+	buf = append(buf, data.Content...)
+	buf = binary.LittleEndian.AppendUint16(buf, data.Checksum)
+	_, err := out.Write(buf)
+	return err
+}
@@ -1,6 +1,7 @@
 package fahivets
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"strings"
@@ -16,12 +17,11 @@ func TestReadRks(t *testing.T) {
 	for _, progsEntry := range progsList {
 		if strings.HasSuffix(progsEntry.Name(), ".rks") {
 			t.Run(progsEntry.Name(), func(t *testing.T) {
-				f, err := os.Open(filepath.Join(progsPath, progsEntry.Name()))
+				raw, err := os.ReadFile(filepath.Join(progsPath, progsEntry.Name()))
 				if err != nil {
 					t.Fatal(err)
 				}
-				t.Cleanup(func() { _ = f.Close() })
-				data, err := ReadRks(f)
+				data, err := ReadRks(bytes.NewReader(raw))
 				if err != nil {
 					t.Error(err)
 				}
@@ -31,6 +31,17 @@ func TestReadRks(t *testing.T) {
 				if len(data.Content) == 0 {
 					t.Errorf("len(data.Content) == 0")
 				}
+				if sum := RksChecksum(data.Content); sum != data.Checksum {
+					t.Errorf("RksChecksum() = %04x; want %04x", sum, data.Checksum)
+				}
+
+				var out bytes.Buffer
+				if err := WriteRks(&out, data); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out.Bytes(), raw) {
+					t.Error("WriteRks output differs from the original file")
+				}
 			})
 		}
 	}
@@ -0,0 +1,203 @@
+package fahivets
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// SpeedUnlimited runs the machine as fast as the host allows.
+var SpeedUnlimited = math.Inf(1)
+
+// LagPolicy defines what Run does when the host falls behind the emulated time.
+type LagPolicy int
+
+const (
+	// CatchUp runs the missed frames without pauses to get back to the real time. The lag longer than
+	// MaxCatchUp is dropped.
+	CatchUp LagPolicy = iota
+	// Skip drops the missed time, the machine runs slower while the host is busy.
+	Skip
+)
+
+// MaxCatchUp is the longest lag CatchUp recovers from, like after the host was suspended.
+const MaxCatchUp = time.Second / 4
+
+// RunOptions configures the Run loop.
+type RunOptions struct {
+	// FrameRate is the number of frames per second of the emulated time, 50 or 60 (the default).
+	FrameRate int
+	// OnFrame is called after every frame is published, in the goroutine of Run. It is the place to pass
+	// the input to the machine.
+	OnFrame func()
+	// Lag is the policy for the host falling behind.
+	Lag LagPolicy
+	// Clock is the wall clock the machine is paced against, the system clock by default.
+	Clock Clock
+}
+
+// Clock provides the wall time to Run.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for the duration, it returns the context error if the context is done earlier.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runControl holds the Run settings changed from other goroutines.
+type runControl struct {
+	mu     sync.Mutex
+	speed  float64
+	paused bool
+	// resume is closed when the machine is resumed.
+	resume chan struct{}
+}
+
+// SetSpeed sets the speed multiplier of Run: 1 is the real clock, 0.5 is half of it, SpeedUnlimited is the turbo
+// mode. It is safe to call from any goroutine.
+func (c *Computer) SetSpeed(speed float64) {
+	c.control.mu.Lock()
+	defer c.control.mu.Unlock()
+	if speed > 0 {
+		c.control.speed = speed
+	}
+}
+
+// Speed returns the speed multiplier of Run.
+func (c *Computer) Speed() float64 {
+	c.control.mu.Lock()
+	defer c.control.mu.Unlock()
+	return c.control.speed
+}
+
+// Pause stops Run after the current frame until Resume is called. It is safe to call from any goroutine.
+func (c *Computer) Pause() {
+	c.control.mu.Lock()
+	defer c.control.mu.Unlock()
+	if !c.control.paused {
+		c.control.paused = true
+		c.control.resume = make(chan struct{})
+	}
+}
+
+// Resume continues the paused Run.
+func (c *Computer) Resume() {
+	c.control.mu.Lock()
+	defer c.control.mu.Unlock()
+	if c.control.paused {
+		c.control.paused = false
+		close(c.control.resume)
+	}
+}
+
+// Paused reports whether Run is paused.
+func (c *Computer) Paused() bool {
+	c.control.mu.Lock()
+	defer c.control.mu.Unlock()
+	return c.control.paused
+}
+
+// Run executes the machine in real time until the context is cancelled or the CPU fails. The emulated cycles are
+// paced against the wall clock with the speed multiplier. After every frame of the emulated time the display is
+// published (see Frames), and the frame callback is called.
+func (c *Computer) Run(ctx context.Context, opts RunOptions) error {
+	rate := uint64(opts.FrameRate)
+	if rate == 0 {
+		rate = 60
+	}
+	frameCycles := ClockFrequency / rate
+	c.clock = opts.Clock
+	if c.clock == nil {
+		c.clock = systemClock{}
+	}
+
+	c.resetPace()
+	speed := c.Speed()
+	next := c.CPU.Cycles + frameCycles
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.waitResume(ctx); err != nil {
+			return err
+		}
+		if s := c.Speed(); s != speed {
+			speed = s
+			c.resetPace()
+		}
+
+		start := c.CPU.Cycles
+		if err := c.RunUntil(next); err != nil {
+			return err
+		}
+		next += frameCycles
+		c.cyclesSinceSleep += c.CPU.Cycles - start
+		c.PublishFrame()
+		if opts.OnFrame != nil {
+			opts.OnFrame()
+		}
+		if err := c.pace(ctx, speed, time.Second/time.Duration(rate), opts.Lag); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Computer) resetPace() {
+	c.cyclesSinceSleep = 0
+	c.lastSleep = c.clock.Now()
+}
+
+// waitResume blocks while the machine is paused.
+func (c *Computer) waitResume(ctx context.Context) error {
+	c.control.mu.Lock()
+	paused, resume := c.control.paused, c.control.resume
+	c.control.mu.Unlock()
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resume:
+		c.resetPace()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pace sleeps until the wall clock reaches the emulated time. The unlimited speed still sleeps briefly once
+// per frame period of the wall time, so that the host event loop (like the one of the browser) is not starved.
+func (c *Computer) pace(ctx context.Context, speed float64, period time.Duration, lag LagPolicy) error {
+	now := c.clock.Now()
+	if math.IsInf(speed, 1) {
+		if now.Sub(c.lastSleep) < period {
+			return nil
+		}
+		c.resetPace()
+		return c.clock.Sleep(ctx, time.Millisecond)
+	}
+
+	emulated := time.Duration(float64(c.cyclesSinceSleep) / (ClockFrequency * speed) * float64(time.Second))
+	wait := c.lastSleep.Add(emulated).Sub(now)
+	switch {
+	case wait > 0:
+		return c.clock.Sleep(ctx, wait)
+	case lag == Skip && -wait > period, -wait > MaxCatchUp:
+		c.resetPace()
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package fahivets_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"rmazur.io/fahivets"
+	"rmazur.io/fahivets/arch"
+)
+
+// loopComputer returns the machine running an infinite loop.
+func loopComputer() *fahivets.Computer {
+	m := fahivets.NewComputer()
+	arch.EncodeInstructions([]arch.Instruction{arch.NOP(), arch.JMP(0)}, m.CPU.Memory[:])
+	return m
+}
+
+// fakeClock is the wall clock that moves only when Run sleeps or the test simulates the host work.
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.now = c.now.Add(d)
+	return nil
+}
+
+// runFrames runs the machine for the number of frames and returns the wall time it took, measured with
+// the clock of the options if it's set.
+func runFrames(t *testing.T, m *fahivets.Computer, frames int, opts fahivets.RunOptions) time.Duration {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	onFrame := opts.OnFrame
+	n := 0
+	opts.OnFrame = func() {
+		if onFrame != nil {
+			onFrame()
+		}
+		if n++; n == frames {
+			cancel()
+		}
+	}
+	now := time.Now
+	if opts.Clock != nil {
+		now = opts.Clock.Now
+	}
+	start := now()
+	if err := m.Run(ctx, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v; want context.Canceled", err)
+	}
+	if n != frames {
+		t.Errorf("got %d frames; want %d", n, frames)
+	}
+	return now().Sub(start)
+}
+
+// checkDuration allows the rounding errors of converting the cycles to the wall time.
+func checkDuration(t *testing.T, name string, got, want time.Duration) {
+	t.Helper()
+	if d := got - want; d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("%s took %s; want %s", name, got, want)
+	}
+}
+
+func TestRunSpeed(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		speed float64
+		rate  int
+		// host is the wall time the host spends on every frame.
+		host time.Duration
+		// want is the duration of 10 frames, the last frame does not wait.
+		want time.Duration
+	}{
+		{name: "real", speed: 1, rate: 60, want: 9 * time.Second / 60},
+		{name: "real/busy host", speed: 1, rate: 60, host: 5 * time.Millisecond, want: 9*time.Second/60 + 5*time.Millisecond},
+		{name: "half", speed: 0.5, rate: 50, want: 2 * 9 * time.Second / 50},
+		{name: "turbo", speed: fahivets.SpeedUnlimited, rate: 60},
+		// Turbo still sleeps for a millisecond once per frame period of the wall time.
+		{name: "turbo/busy host", speed: fahivets.SpeedUnlimited, rate: 60, host: 5 * time.Millisecond, want: 52 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := loopComputer()
+			m.SetSpeed(tc.speed)
+			clock := &fakeClock{now: time.Unix(0, 0)}
+			frameCycles := uint64(fahivets.ClockFrequency / tc.rate)
+			var cycles []uint64
+			elapsed := runFrames(t, m, 10, fahivets.RunOptions{
+				FrameRate: tc.rate,
+				Clock:     clock,
+				OnFrame: func() {
+					cycles = append(cycles, m.CPU.Cycles)
+					clock.now = clock.now.Add(tc.host)
+				},
+			})
+			checkDuration(t, "10 frames", elapsed, tc.want)
+			for i, c := range cycles {
+				// The frame ends after the instruction that reaches its cycle.
+				if want := uint64(i+1) * frameCycles; c < want || c > want+10 {
+					t.Errorf("frame %d ended at cycle %d; want %d", i, c, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunPause(t *testing.T) {
+	m := loopComputer()
+	m.SetSpeed(fahivets.SpeedUnlimited)
+	const pause = 100 * time.Millisecond
+	n := 0
+	elapsed := runFrames(t, m, 3, fahivets.RunOptions{OnFrame: func() {
+		if n++; n == 1 {
+			m.Pause()
+			if !m.Paused() {
+				t.Error("the machine is not paused")
+			}
+			time.AfterFunc(pause, m.Resume)
+		}
+	}})
+	if elapsed < pause {
+		t.Errorf("3 frames took %s; want at least the pause of %s", elapsed, pause)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	m.Pause()
+	cycles := m.CPU.Cycles
+	if err := m.Run(ctx, fahivets.RunOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v; want context.DeadlineExceeded", err)
+	}
+	if m.CPU.Cycles != cycles {
+		t.Errorf("the paused machine ran %d cycles", m.CPU.Cycles-cycles)
+	}
+}
+
+func TestRunLag(t *testing.T) {
+	// The host is busy after the first frame, the machine runs 15 frames at 60 Hz.
+	const frames = 15
+	frame := time.Second / 60
+	for _, tc := range []struct {
+		name string
+		lag  fahivets.LagPolicy
+		busy time.Duration
+		want time.Duration
+	}{
+		// Catching up keeps the total time of the frames.
+		{name: "catch up", lag: fahivets.CatchUp, busy: 200 * time.Millisecond, want: (frames - 1) * frame},
+		// The lag longer than MaxCatchUp is dropped.
+		{name: "catch up/too long", lag: fahivets.CatchUp, busy: 400 * time.Millisecond, want: 400*time.Millisecond + (frames-2)*frame},
+		// Skipping adds the busy time to the frames.
+		{name: "skip", lag: fahivets.Skip, busy: 200 * time.Millisecond, want: 200*time.Millisecond + (frames-2)*frame},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := loopComputer()
+			clock := &fakeClock{now: time.Unix(0, 0)}
+			n := 0
+			elapsed := runFrames(t, m, frames, fahivets.RunOptions{Lag: tc.lag, Clock: clock, OnFrame: func() {
+				if n++; n == 1 {
+					clock.now = clock.now.Add(tc.busy)
+				}
+			}})
+			checkDuration(t, fmt.Sprint(frames, " frames"), elapsed, tc.want)
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package fahivets_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rmazur.io/fahivets/internal/scenario"
+)
+
+// TestScenarios runs the scenarios from testdata/scenarios, see the scenario package for the format.
+// The snapshots are written to testdata/examples.
+func TestScenarios(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "scenarios", "*.scenario"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := scenario.Env{
+		Bootloader: readData(t, "progs/bootloader.rom"),
+		Monitor:    readData(t, "progs/monitor.rom"),
+		Dir:        "testdata",
+		Output:     filepath.Join("testdata", "examples"),
+	}
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".scenario"), func(t *testing.T) {
+			s, err := scenario.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			env := env
+			env.Logf = t.Logf
+			if err := s.Run(env); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package fahivets
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"rmazur.io/fahivets/internal/wav"
+)
+
+// TapeConfig defines the parameters of the cassette signal.
+//
+// Фахівець records every bit as a cell of two halves with the opposite levels: the first half carries the bit value,
+// and the second one carries its inverse. Bytes are written starting from the most significant bit.
+// A file on the tape is a pilot of zero bytes, followed by the sync byte 0xE6, and the same content as the RKS file
+// has: start and end addresses, the program, and its checksum.
+type TapeConfig struct {
+	SampleRate int
+	// HalfPeriod is the duration of a half of the bit cell.
+	HalfPeriod time.Duration
+	// PilotBytes is the number of zero bytes written before the sync byte.
+	PilotBytes int
+	// Gap is the duration of silence around the recorded files.
+	Gap time.Duration
+}
+
+// DefaultTapeConfig matches the timings of the bootloader tape routines (delay constant 0x28 at 2 MHz).
+var DefaultTapeConfig = TapeConfig{
+	SampleRate: 44100,
+	HalfPeriod: 350 * time.Microsecond,
+	PilotBytes: 256,
+	Gap:        time.Second,
+}
+
+const (
+	tapeSyncByte = 0xE6
+	tapeLevel    = 0.8
+)
+
+var (
+	ErrTapeChecksum   = errors.New("checksum mismatch")
+	ErrTapeSignalLost = errors.New("signal lost")
+	ErrTapeBadBit     = errors.New("corrupted bit")
+	ErrTapeBadHeader  = errors.New("end address precedes the start address")
+)
+
+// TapeBlock is a file recovered from a cassette recording.
+// Data is filled with whatever was decoded even if Err is not nil.
+type TapeBlock struct {
+	Data RksData
+	// Offset is the position of the block in the recording.
+	Offset time.Duration
+	Err    error
+}
+
+// EncodeTape generates the cassette signal for the files.
+func EncodeTape(config TapeConfig, files ...RksData) []float64 {
+	var (
+		samples    []float64
+		pos        float64
+		halfPeriod = float64(config.SampleRate) * config.HalfPeriod.Seconds()
+		gap        = int(float64(config.SampleRate) * config.Gap.Seconds())
+	)
+	appendLevel := func(level float64, duration float64) {
+		pos += duration
+		for len(samples) < int(math.Round(pos)) {
+			samples = append(samples, level)
+		}
+	}
+	appendByte := func(v byte) {
+		for i := 7; i >= 0; i-- {
+			if (v>>i)&1 == 1 {
+				appendLevel(tapeLevel, halfPeriod)
+				appendLevel(-tapeLevel, halfPeriod)
+			} else {
+				appendLevel(-tapeLevel, halfPeriod)
+				appendLevel(tapeLevel, halfPeriod)
+			}
+		}
+	}
+
+	appendLevel(0, float64(gap))
+	for _, file := range files {
+		for range config.PilotBytes {
+			appendByte(0)
+		}
+		appendByte(tapeSyncByte)
+		var header [4]byte
+		binary.LittleEndian.PutUint16(header[0:], file.StartAddress)
+		binary.LittleEndian.PutUint16(header[2:], file.EndAddress)
+		for _, b := range header {
+			appendByte(b)
+		}
+		for _, b := range file.Content {
+			appendByte(b)
+		}
+		appendByte(byte(file.Checksum))
+		appendByte(byte(file.Checksum >> 8))
+		appendLevel(0, float64(gap))
+	}
+	return samples
+}
+
+// WriteTapeWav writes the files as a WAVE recording that can be played to the computer tape input.
+func WriteTapeWav(out io.Writer, config TapeConfig, files ...RksData) error {
+	return wav.Write(out, wav.Audio{SampleRate: config.SampleRate, Samples: EncodeTape(config, files...)})
+}
+
+// ReadTapeWav decodes all the files found in a WAVE recording.
+func ReadTapeWav(in io.Reader) ([]TapeBlock, error) {
+	audio, err := wav.Read(in)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeTape(audio.Samples, audio.SampleRate), nil
+}
+
+// DecodeTape demodulates the cassette signal.
+// The decoder adapts to DC offsets, the signal amplitude, and the tape speed, which is allowed to drift over
+// the recording. Inverted signals are recognized by the inverted sync byte.
+func DecodeTape(samples []float64, sampleRate int) []TapeBlock {
+	d := tapeDecoder{sampleRate: sampleRate}
+	for _, e := range tapeEdges(samples, sampleRate) {
+		d.edge(e)
+	}
+	d.finish()
+	return d.blocks
+}
+
+type tapeEdge struct {
+	pos   int  // Sample index.
+	level bool // Level after the edge.
+}
+
+// tapeEdges converts the analog signal into a sequence of level changes using a Schmitt trigger
+// with thresholds that follow the signal envelope.
+func tapeEdges(samples []float64, sampleRate int) []tapeEdge {
+	var (
+		res []tapeEdge
+
+		window   = max(1, sampleRate/15000) // Low-pass moving average.
+		dcAlpha  = 1 / (0.002 * float64(sampleRate))
+		envDecay = 1 - 1/(0.005*float64(sampleRate))
+
+		sum, dc, env float64
+		level        bool
+	)
+	if len(samples) > 0 {
+		dc = samples[0]
+	}
+	for i, s := range samples {
+		sum += s
+		if i >= window {
+			sum -= samples[i-window]
+		}
+		x := sum / float64(min(i+1, window))
+		dc += (x - dc) * dcAlpha
+		x -= dc
+		env = max(math.Abs(x), env*envDecay)
+
+		threshold := max(env/4, 0.01)
+		if level && x < -threshold || !level && x > threshold {
+			level = !level
+			res = append(res, tapeEdge{pos: i, level: level})
+		}
+	}
+	return res
+}
+
+type tapeDecoderState int
+
+const (
+	tapeHunt tapeDecoderState = iota // Looking for a pilot.
+	tapeSync                         // Pilot found, looking for the sync byte.
+	tapeData                         // Reading the file.
+)
+
+const (
+	// Programs may have long sequences of zeros, so a few bytes are not enough to detect the pilot.
+	tapePilotIntervals = 512
+	tapeSyncHalves     = 16
+)
+
+type tapeDecoder struct {
+	sampleRate int
+	blocks     []TapeBlock
+
+	state tapeDecoderState
+	last  tapeEdge
+	// Estimated duration of the half-period in samples.
+	half float64
+	// Number of consecutive intervals that look like the pilot.
+	pilot int
+	// Position of the pilot start.
+	start int
+	// Number of half-periods since the end of the pilot, or -1 if it has not ended yet.
+	syncHalves int
+
+	halves         []bool
+	inverted       bool
+	data           []byte
+	bitsInLastByte int
+}
+
+func (d *tapeDecoder) edge(e tapeEdge) {
+	interval := float64(e.pos - d.last.pos)
+	level := d.last.level
+	d.last = e
+
+	if d.state == tapeHunt {
+		d.hunt(interval, e.pos)
+		return
+	}
+
+	n := 0
+	switch {
+	case interval < 1.5*d.half:
+		n = 1
+	case interval < 2.5*d.half:
+		n = 2
+	default:
+		d.lost(level)
+		d.hunt(interval, e.pos)
+		return
+	}
+	d.half += (interval/float64(n) - d.half) * 0.05
+	if n == 2 && d.syncHalves < 0 {
+		d.syncHalves = 0
+	}
+
+	for range n {
+		d.halves = append(d.halves, level)
+		if d.state == tapeSync {
+			d.sync()
+		} else if len(d.halves) == 2 {
+			d.bit()
+		}
+		if d.state == tapeHunt {
+			return
+		}
+	}
+}
+
+func (d *tapeDecoder) hunt(interval float64, pos int) {
+	if d.pilot > 0 && interval > d.half*0.6 && interval < d.half*1.4 {
+		d.pilot++
+		d.half += (interval - d.half) / float64(min(d.pilot, 16))
+	} else {
+		d.pilot = 1
+		d.half = interval
+		d.start = pos - int(interval)
+	}
+	if d.pilot < tapePilotIntervals {
+		return
+	}
+	d.state = tapeSync
+	d.pilot = 0
+	d.halves = d.halves[:0]
+	d.syncHalves = -1
+}
+
+func (d *tapeDecoder) sync() {
+	if d.syncHalves >= 0 {
+		d.syncHalves++
+		if d.syncHalves > 2*tapeSyncHalves {
+			// The sync byte must follow the pilot.
+			d.state = tapeHunt
+			return
+		}
+	}
+	if len(d.halves) < tapeSyncHalves {
+		return
+	}
+	d.halves = d.halves[len(d.halves)-tapeSyncHalves:]
+	value, valid := decodeHalves(d.halves)
+	if !valid {
+		return
+	}
+	switch value {
+	case tapeSyncByte:
+		d.inverted = false
+	case ^byte(tapeSyncByte):
+		d.inverted = true
+	default:
+		return
+	}
+	d.state = tapeData
+	d.halves = d.halves[:0]
+	d.data = d.data[:0]
+}
+
+// decodeHalves converts pairs of half-period levels into bits.
+// The result is not valid if any pair has equal levels.
+func decodeHalves(halves []bool) (value byte, valid bool) {
+	valid = true
+	for i := 0; i < len(halves); i += 2 {
+		value <<= 1
+		if halves[i] {
+			value |= 1
+		}
+		valid = valid && halves[i] != halves[i+1]
+	}
+	return
+}
+
+func (d *tapeDecoder) bit() {
+	first, second := d.halves[0], d.halves[1]
+	d.halves = d.halves[:0]
+	if first == second {
+		d.fail(fmt.Errorf("%w in byte %d", ErrTapeBadBit, len(d.data)))
+		return
+	}
+	if d.inverted {
+		first = !first
+	}
+
+	if len(d.data) == 0 || d.bitsInLastByte == 8 {
+		d.data = append(d.data, 0)
+		d.bitsInLastByte = 0
+	}
+	last := &d.data[len(d.data)-1]
+	*last <<= 1
+	if first {
+		*last |= 1
+	}
+	d.bitsInLastByte++
+	if d.bitsInLastByte < 8 {
+		return
+	}
+
+	if len(d.data) == 4 {
+		start, end := binary.LittleEndian.Uint16(d.data[0:]), binary.LittleEndian.Uint16(d.data[2:])
+		if end < start {
+			d.fail(ErrTapeBadHeader)
+			return
+		}
+	}
+	if len(d.data) >= 4 && len(d.data) == d.expectedLen() {
+		d.complete(nil)
+	}
+}
+
+func (d *tapeDecoder) expectedLen() int {
+	start, end := binary.LittleEndian.Uint16(d.data[0:]), binary.LittleEndian.Uint16(d.data[2:])
+	// Header, content, and checksum.
+	return 4 + int(end-start) + 1 + 2
+}
+
+func (d *tapeDecoder) fail(err error) {
+	if d.state == tapeData {
+		d.complete(err)
+	}
+	d.state = tapeHunt
+}
+
+func (d *tapeDecoder) complete(err error) {
+	var block TapeBlock
+	block.Offset = time.Duration(float64(d.start) / float64(d.sampleRate) * float64(time.Second))
+
+	data := d.data
+	if d.bitsInLastByte < 8 && len(data) > 0 {
+		data = data[:len(data)-1]
+	}
+	if len(data) >= 4 {
+		block.Data.StartAddress = binary.LittleEndian.Uint16(data[0:])
+		block.Data.EndAddress = binary.LittleEndian.Uint16(data[2:])
+		content := data[4:]
+		if err == nil {
+			block.Data.Checksum = binary.LittleEndian.Uint16(content[len(content)-2:])
+			content = content[:len(content)-2]
+			if RksChecksum(content) != block.Data.Checksum {
+				err = ErrTapeChecksum
+			}
+		}
+		block.Data.Content = append([]byte(nil), content...)
+	}
+	if err != nil {
+		block.Err = fmt.Errorf("block at %s, %d bytes: %w", block.Offset, len(data), err)
+	}
+	d.blocks = append(d.blocks, block)
+
+	d.state = tapeHunt
+	d.data = d.data[:0]
+	d.bitsInLastByte = 0
+}
+
+// lost handles the end of the signal. The last level lasted for at least a half-period, which may be enough
+// to complete the block.
+func (d *tapeDecoder) lost(level bool) {
+	if d.state == tapeData {
+		d.halves = append(d.halves, level)
+		if len(d.halves) == 2 {
+			d.bit()
+		}
+	}
+	if d.state != tapeHunt {
+		d.fail(ErrTapeSignalLost)
+	}
+}
+
+func (d *tapeDecoder) finish() {
+	d.lost(d.last.level)
+}
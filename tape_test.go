@@ -0,0 +1,178 @@
+package fahivets_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"rmazur.io/fahivets"
+)
+
+func TestTapeWavRoundTrip(t *testing.T) {
+	files := []fahivets.RksData{
+		readRks(t, "progs/starwars.rks"),
+		readRks(t, "progs/nardy.rks"),
+	}
+
+	var recording bytes.Buffer
+	err := fahivets.WriteTapeWav(&recording, fahivets.DefaultTapeConfig, files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := fahivets.ReadTapeWav(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTapeBlocks(t, blocks, files)
+}
+
+func TestTapeDistortions(t *testing.T) {
+	file := readRks(t, "progs/starwars.rks")
+	config := fahivets.DefaultTapeConfig
+
+	for _, tc := range []struct {
+		name    string
+		config  func(c *fahivets.TapeConfig)
+		distort func(samples []float64) []float64
+	}{
+		{
+			name: "noise",
+			distort: func(samples []float64) []float64 {
+				rnd := rand.New(rand.NewSource(42))
+				for i := range samples {
+					samples[i] += rnd.NormFloat64() * 0.15
+				}
+				return samples
+			},
+		},
+		{
+			name: "level-shift",
+			distort: func(samples []float64) []float64 {
+				for i := range samples {
+					// Quiet signal with a slowly drifting offset.
+					samples[i] = samples[i]*0.2 + 0.5 + 0.2*math.Sin(float64(i)/20000)
+				}
+				return samples
+			},
+		},
+		{
+			name: "inverted",
+			distort: func(samples []float64) []float64 {
+				for i := range samples {
+					samples[i] = -samples[i]
+				}
+				return samples
+			},
+		},
+		{
+			name: "speed-drift",
+			distort: func(samples []float64) []float64 {
+				// The playback speed changes from 92% to 108% over the recording.
+				var (
+					res []float64
+					pos float64
+				)
+				for pos < float64(len(samples)-1) {
+					i := int(pos)
+					frac := pos - float64(i)
+					res = append(res, samples[i]*(1-frac)+samples[i+1]*frac)
+					pos += 0.92 + 0.16*pos/float64(len(samples))
+				}
+				return res
+			},
+		},
+		{
+			name:   "low-sample-rate",
+			config: func(c *fahivets.TapeConfig) { c.SampleRate = 11025 },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := config
+			if tc.config != nil {
+				tc.config(&c)
+			}
+			samples := fahivets.EncodeTape(c, file)
+			if tc.distort != nil {
+				samples = tc.distort(samples)
+			}
+			checkTapeBlocks(t, fahivets.DecodeTape(samples, c.SampleRate), []fahivets.RksData{file})
+		})
+	}
+}
+
+func TestTapeErrors(t *testing.T) {
+	file := readRks(t, "progs/starwars.rks")
+	config := fahivets.DefaultTapeConfig
+
+	t.Run("checksum", func(t *testing.T) {
+		corrupted := file
+		corrupted.Checksum++
+		blocks := fahivets.DecodeTape(fahivets.EncodeTape(config, corrupted, file), config.SampleRate)
+		if len(blocks) != 2 {
+			t.Fatalf("got %d blocks; want 2", len(blocks))
+		}
+		if !errors.Is(blocks[0].Err, fahivets.ErrTapeChecksum) {
+			t.Errorf("got error %v; want checksum mismatch", blocks[0].Err)
+		}
+		if !bytes.Equal(blocks[0].Data.Content, file.Content) {
+			t.Error("content of the corrupted block is not recovered")
+		}
+		if blocks[1].Err != nil {
+			t.Errorf("unexpected error in the second block: %s", blocks[1].Err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		samples := fahivets.EncodeTape(config, file)
+		blocks := fahivets.DecodeTape(samples[:len(samples)/2], config.SampleRate)
+		if len(blocks) != 1 {
+			t.Fatalf("got %d blocks; want 1", len(blocks))
+		}
+		if !errors.Is(blocks[0].Err, fahivets.ErrTapeSignalLost) {
+			t.Errorf("got error %v; want lost signal", blocks[0].Err)
+		}
+		t.Log(blocks[0].Err)
+	})
+
+	t.Run("dropout", func(t *testing.T) {
+		samples := fahivets.EncodeTape(config, file)
+		mid := len(samples) / 2
+		clear(samples[mid : mid+config.SampleRate/100])
+		blocks := fahivets.DecodeTape(samples, config.SampleRate)
+		if len(blocks) != 1 {
+			t.Fatalf("got %d blocks; want 1", len(blocks))
+		}
+		if blocks[0].Err == nil {
+			t.Error("dropout is not reported")
+		}
+		t.Log(blocks[0].Err)
+	})
+}
+
+func checkTapeBlocks(t *testing.T, blocks []fahivets.TapeBlock, want []fahivets.RksData) {
+	t.Helper()
+	if len(blocks) != len(want) {
+		for _, b := range blocks {
+			t.Log(b.Offset, b.Err)
+		}
+		t.Fatalf("got %d blocks; want %d", len(blocks), len(want))
+	}
+	for i, b := range blocks {
+		if b.Err != nil {
+			t.Errorf("block %d: %s", i, b.Err)
+		}
+		got := b.Data
+		if got.StartAddress != want[i].StartAddress || got.EndAddress != want[i].EndAddress {
+			t.Errorf("block %d: got addresses %04x-%04x; want %04x-%04x",
+				i, got.StartAddress, got.EndAddress, want[i].StartAddress, want[i].EndAddress)
+		}
+		if got.Checksum != want[i].Checksum {
+			t.Errorf("block %d: got checksum %04x; want %04x", i, got.Checksum, want[i].Checksum)
+		}
+		if !bytes.Equal(got.Content, want[i].Content) {
+			t.Errorf("block %d: content mismatch", i)
+		}
+	}
+}